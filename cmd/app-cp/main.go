@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+// remotePrefix marks the argument that refers to a path on the app container
+const remotePrefix = "app:"
+
+var (
+	jobID       = flag.String("jobId", "", "Nomad job ID of the app to copy files to or from")
+	namespace   = flag.String("namespace", appexec.SitesNamespace, "Nomad namespace of the app jobs (default: sites)")
+	taskName    = flag.String("task", appexec.AppUnitTaskName, "Task in the allocation to copy files to or from (default: app-unit)")
+	execUser    = flag.String("execUser", appexec.CustomerUser, "User to read and write files as inside the task (default: customer)")
+	allocSelect = flag.String("allocSelect", string(appexec.AllocSelectFirst), "Allocation of the job to copy files to or from: first, newest, alloc:<id> or node:<id> (default: first)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] SRC DST\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Exactly one of SRC or DST must be a path on the app prefixed with %q, e.g.\n", remotePrefix)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -jobId app-12345 ./my-plugin app:wp-content/plugins\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -jobId app-12345 app:wp-content/uploads ./uploads\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *jobID == "" || flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	src, dst := flag.Arg(0), flag.Arg(1)
+	srcRemote, dstRemote := strings.HasPrefix(src, remotePrefix), strings.HasPrefix(dst, remotePrefix)
+	if srcRemote == dstRemote {
+		log.Fatalf("Exactly one of SRC or DST must be prefixed with %q", remotePrefix)
+	}
+
+	allocSelector, err := appexec.ParseAllocSelector(*allocSelect)
+	if err != nil {
+		log.Fatalf("Invalid -allocSelect: %v", err)
+	}
+
+	// Create Nomad client
+	nomadClient, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		log.Fatalf("Error creating Nomad client: %v", err)
+	}
+
+	appExec := appexec.NewAppExec(nomadClient, 1, appexec.Options{
+		Namespace:     *namespace,
+		TaskName:      *taskName,
+		ExecUser:      *execUser,
+		AllocSelector: allocSelector,
+	})
+
+	start := time.Now()
+	ctx := context.Background()
+	var result *appexec.CopyResult
+	if dstRemote {
+		result, err = appExec.CopyToApp(ctx, *jobID, src, strings.TrimPrefix(dst, remotePrefix))
+	} else {
+		result, err = appExec.CopyFromApp(ctx, *jobID, strings.TrimPrefix(src, remotePrefix), dst)
+	}
+	if err != nil {
+		log.Fatalf("Copy failed: %v", err)
+	}
+
+	log.Printf("Copied %d files (%d bytes) from %s to %s on allocation %s in %v, checksums verified",
+		result.Files, result.Bytes, src, dst, result.AllocID, time.Since(start))
+}
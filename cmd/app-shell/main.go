@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+	"golang.org/x/term"
+)
+
+var (
+	jobID       = flag.String("jobId", "", "Nomad job ID of the app to open a shell on")
+	accountID   = flag.String("accountId", "", "Account ID of the app to open a shell on, must have exactly one app job (optional)")
+	namespace   = flag.String("namespace", appexec.SitesNamespace, "Nomad namespace of the app jobs (default: sites)")
+	taskName    = flag.String("task", appexec.AppUnitTaskName, "Task in the allocation to open the shell in (default: app-unit)")
+	execUser    = flag.String("execUser", appexec.CustomerUser, "User to log in as inside the task (default: customer)")
+	allocSelect = flag.String("allocSelect", string(appexec.AllocSelectFirst), "Allocation of the job to open the shell on: first, newest, alloc:<id> or node:<id> (default: first)")
+)
+
+func main() {
+	flag.Parse()
+
+	if (*jobID == "") == (*accountID == "") {
+		log.Fatal("Exactly one of -jobId or -accountId is required")
+	}
+
+	allocSelector, err := appexec.ParseAllocSelector(*allocSelect)
+	if err != nil {
+		log.Fatalf("Invalid -allocSelect: %v", err)
+	}
+
+	// Create Nomad client
+	nomadClient, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		log.Fatalf("Error creating Nomad client: %v", err)
+	}
+
+	appExec := appexec.NewAppExec(nomadClient, 1, appexec.Options{
+		Namespace:     *namespace,
+		TaskName:      *taskName,
+		ExecUser:      *execUser,
+		AllocSelector: allocSelector,
+	})
+
+	ctx := context.Background()
+	job, err := resolveJobID(ctx, appExec, *jobID, *accountID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Resolve the allocation the same way the exec tools do
+	allocID, err := appExec.GetAppUnitAllocId(ctx, job)
+	if err != nil {
+		log.Fatalf("Error finding allocation for job %s: %v", job, err)
+	}
+	log.Printf("Opening shell as %s in task %s of allocation %s for job %s", *execUser, *taskName, allocID, job)
+
+	exitCode, err := openShell(ctx, appExec, allocID)
+	if err != nil {
+		log.Fatalf("Shell failed: %v", err)
+	}
+	os.Exit(exitCode)
+}
+
+// resolveJobID returns the job ID to open a shell on, looking up the single app job for an account if needed
+func resolveJobID(ctx context.Context, appExec *appexec.AppExec, jobID, accountID string) (string, error) {
+	if jobID != "" {
+		return jobID, nil
+	}
+
+	jobs, err := appExec.GetAppJobs(ctx, accountID)
+	if err != nil {
+		return "", fmt.Errorf("error getting app jobs for account %s: %w", accountID, err)
+	}
+	switch len(jobs) {
+	case 0:
+		return "", fmt.Errorf("no jobs found for account ID: %s", accountID)
+	case 1:
+		return jobs[0], nil
+	default:
+		return "", fmt.Errorf("account %s has %d app jobs, pick one with -jobId: %s", accountID, len(jobs), strings.Join(jobs, ", "))
+	}
+}
+
+// openShell puts the local terminal in raw mode, forwards resizes, and runs the remote shell until it exits
+func openShell(ctx context.Context, appExec *appexec.AppExec, allocID string) (int, error) {
+	stdinFd := int(os.Stdin.Fd())
+	if term.IsTerminal(stdinFd) {
+		oldState, err := term.MakeRaw(stdinFd)
+		if err != nil {
+			return -1, fmt.Errorf("failed to put terminal in raw mode: %w", err)
+		}
+		defer term.Restore(stdinFd, oldState)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sizeCh := watchTerminalSize(ctx, int(os.Stdout.Fd()))
+
+	return appExec.ExecShellOnAllocation(ctx, allocID, os.Stdin, os.Stdout, os.Stderr, sizeCh)
+}
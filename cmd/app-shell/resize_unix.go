@@ -0,0 +1,48 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hashicorp/nomad/api"
+	"golang.org/x/term"
+)
+
+// watchTerminalSize sends the current terminal size and then every change signalled by SIGWINCH until ctx is done
+func watchTerminalSize(ctx context.Context, fd int) <-chan api.TerminalSize {
+	sizeCh := make(chan api.TerminalSize, 1)
+	if !term.IsTerminal(fd) {
+		return sizeCh
+	}
+
+	sendSize := func() {
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			return
+		}
+		select {
+		case sizeCh <- api.TerminalSize{Width: width, Height: height}:
+		case <-ctx.Done():
+		}
+	}
+
+	winchChan := make(chan os.Signal, 1)
+	signal.Notify(winchChan, syscall.SIGWINCH)
+	go func() {
+		defer signal.Stop(winchChan)
+		sendSize()
+		for {
+			select {
+			case <-winchChan:
+				sendSize()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return sizeCh
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+
+	"github.com/hashicorp/nomad/api"
+	"golang.org/x/term"
+)
+
+// watchTerminalSize sends the terminal size once, Windows has no SIGWINCH to watch for resizes
+func watchTerminalSize(ctx context.Context, fd int) <-chan api.TerminalSize {
+	sizeCh := make(chan api.TerminalSize, 1)
+	if width, height, err := term.GetSize(fd); err == nil {
+		sizeCh <- api.TerminalSize{Width: width, Height: height}
+	}
+	return sizeCh
+}
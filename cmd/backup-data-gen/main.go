@@ -5,98 +5,531 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/fleet"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/inventory"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/metrics"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/profile"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/scripts"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/selector"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/tracing"
 	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
 	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/datagen"
+	"golang.org/x/term"
 )
 
 var (
 	jobID                = flag.String("jobId", "", "Nomad job ID to execute commands on (optional)")
 	accountID            = flag.String("accountId", "", "Account ID to find all jobs for (optional)")
+	jobSelector          = flag.String("selector", "", "Selector for the jobs to find instead of all app jobs, e.g. 'name =~ \"^app-\" and meta.tier in [gold, silver]', combined with -accountId if both are set (optional)")
+	inventoryFile        = flag.String("inventory", "", "Inventory snapshot file to discover jobs and their allocations from instead of the clusters, created if missing (optional)")
+	inventoryTTL         = flag.Duration("inventoryTTL", inventory.DefaultTTL, "Age after which the inventory snapshot is refreshed incrementally before discovering jobs (default: 15m)")
 	jobIDsFile           = flag.String("jobIdsFile", "", "File containing list of job IDs (one per line) (optional)")
 	customCmd            = flag.String("cmd", "", "Custom command to run on the app (optional)")
+	scriptName           = flag.String("script", "", "Script from the built-in library to run on the app instead of generating data, see -listScripts (optional)")
+	listScripts          = flag.Bool("listScripts", false, "List the scripts in the built-in library with their variables and exit")
 	sizeDistributionType = flag.String("size", "medium", "Size distribution for backup generation: medium or large (default: medium)")
 	baseRootDir          = flag.String("rootDir", "./wp-content/mwp-perf-data", "Base root directory for backup generation (default: ./wp-content/mwp-perf-data)")
-	concurrency          = flag.Int("concurrency", appexec.ExecConcurrency, "Number of concurrent execs (default: 5)")
+	concurrency          = flag.Int("concurrency", appexec.ExecConcurrency, "Number of concurrent execs in each cluster (default: 5)")
+	perNodeConcurrency   = flag.Int("perNodeConcurrency", 0, "Number of concurrent execs on allocations of the same Nomad node, 0 for no limit (default: 0)")
+	filterConcurrency    = flag.Int("filterConcurrency", appexec.FilterConcurrency, "Number of concurrent job info lookups when filtering by account without server-side filtering (default: 10)")
 	maxFiles             = flag.Int("maxFiles", 30, "Maximum files per directory (default: 30)")
+	namespace            = flag.String("namespace", appexec.SitesNamespace, "Nomad namespace of the app jobs, overrides the profile's namespace (default: sites)")
+	profName             = flag.String("profile", "", "Cluster profile to target, or a comma separated list to run across several clusters, the Nomad client ENV vars are used if not set (optional)")
+	regions              = flag.String("regions", "", "Comma separated Nomad regions to run across in each cluster, the profile's region if not set (optional)")
+	profFile             = flag.String("profilesFile", profile.DefaultPath(), "File of cluster profiles (default: $PLAT_V2_TOOLS_PROFILES, else profiles.json in the user config dir)")
+	taskName             = flag.String("task", appexec.AppUnitTaskName, "Task in the allocation to exec into, e.g. app-unit, nginx or php-fpm (default: app-unit)")
+	execUser             = flag.String("execUser", appexec.CustomerUser, "User to run the command as inside the task (default: customer)")
+	allocSelect          = flag.String("allocSelect", string(appexec.AllocSelectFirst), "Allocations of each job to exec on: first, newest, all, alloc:<id> or node:<id> (default: first)")
 	logLevel             = flag.String("logLevel", "info", "Log level: debug or info")
+	retryAttempts        = flag.Int("retryAttempts", 3, "Maximum attempts for each Nomad call on transient errors, 1 disables retries (default: 3)")
+	retryBackoff         = flag.Duration("retryBackoff", 500*time.Millisecond, "Initial backoff between retries of a Nomad call, doubled on each attempt (default: 500ms)")
+	retryStartedExecs    = flag.Bool("retryStartedExecs", false, "Also retry execs that fail after their command started, which runs it again, only for idempotent commands (default: false)")
+	allocWait            = flag.Duration("allocWait", 0, "How long to wait for a job with no running allocation to get one, e.g. while it is rescheduled, 0 to fail the job at once (default: 0)")
+	execTimeout          = flag.Duration("execTimeout", 0, "Timeout for each exec on a job, 0 for no timeout (default: 0)")
+	runTimeout           = flag.Duration("runTimeout", 0, "Timeout for the whole run, in-flight execs are cancelled when it expires, 0 for no timeout (default: 0)")
+	reportFile           = flag.String("report", "", "File to write a run report to with one row per exec and totals (optional)")
+	reportFormat         = flag.String("reportFormat", "", "Run report format: json or csv (default: from the -report file extension, else json)")
+	successExitCodes     = flag.String("successExitCodes", "0", "Comma separated remote exit codes and ranges that count as success, e.g. 0,3,10-12 (default: 0)")
+	maxFailures          = flag.Int("maxFailures", 0, "Failed jobs allowed before the run exits non-zero, -1 for no limit (default: 0)")
+	maxFailureRate       = flag.Float64("maxFailureRate", 1, "Fraction of failed jobs allowed before the run exits non-zero, e.g. 0.05, 1 for no limit (default: 1)")
+	checkpointFile       = flag.String("checkpoint", "", "File to append each job's outcome to as it completes, so the run can be resumed with -resume (optional)")
+	resumeFile           = flag.String("resume", "", "Checkpoint file of a run to resume, retrying its failed and not started jobs with its arguments, which flags given with -resume override (optional)")
+	metricsAddr          = flag.String("metricsAddr", "", "Address to serve Prometheus metrics on at /metrics during the run, e.g. :9100 (optional)")
+	otlpEndpoint         = flag.String("otlpEndpoint", "", "OTLP/HTTP collector URL to export trace spans of the run to, e.g. http://localhost:4318 (optional)")
+	traceFile            = flag.String("traceFile", "", "File to write trace spans of the run to as JSON, for offline inspection (optional)")
+	progressInterval     = flag.Duration("progressInterval", 30*time.Second, "Interval between progress logs with jobs queued, running and done, throughput and ETA, 0 to disable, a terminal gets a status line updated every second instead (default: 30s)")
+	dryRun               = flag.Bool("dryRun", false, "Resolve the jobs and allocations and log the plan with the expected data without executing anything (default: false)")
+	dryRunDir            = flag.String("dryRunDir", "", "Directory to write the generated script of each exec to in a dry run (optional)")
+	dryRunScripts        = flag.String("dryRunScripts", "one", "Execs to write the generated script of in a dry run: one or all (default: one)")
+	outputDir            = flag.String("outputDir", "", "Directory to write each job's stdout, stderr and meta.json to, in <jobID>/ (optional)")
+	outputArchive        = flag.Bool("outputArchive", false, "Archive the -outputDir to <outputDir>.tar.gz at the end of the run, e.g. to attach to a ticket (default: false)")
+	maxOutputBytes       = flag.Int("maxOutputBytes", 1024*1024, "Maximum bytes of stdout and stderr each kept in memory per exec, 0 for no limit (default: 1MB)")
 )
 
+var scriptVars = scripts.VarsFlag{}
+
+func init() {
+	flag.Var(scriptVars, "var", "Variable of the -script as name=value, repeat for each variable (optional)")
+}
+
 func main() {
 	flag.Parse()
 
+	// A resumed run repeats the arguments of the original run, then the flags given with -resume override them
+	var resumeState *fleet.CheckpointState
+	if *resumeFile != "" {
+		var err error
+		if resumeState, err = fleet.LoadCheckpoint(*resumeFile); err != nil {
+			log.Fatalf("Error loading checkpoint: %v", err)
+		}
+		// Parse errors are returned rather than exiting so they can name the checkpoint
+		flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+		if err := flag.CommandLine.Parse(resumeState.Header.Args); err != nil {
+			log.Fatalf("Error parsing the arguments saved in checkpoint %s: %v", *resumeFile, err)
+		}
+		if flag.NArg() > 0 {
+			log.Fatalf("Error parsing the arguments saved in checkpoint %s: unexpected argument %q", *resumeFile, flag.Arg(0))
+		}
+		if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+			log.Fatalf("Error parsing the arguments given with checkpoint %s: %v", *resumeFile, err)
+		}
+	}
+
+	if *listScripts {
+		if err := printScripts(os.Stdout); err != nil {
+			log.Fatalf("Error loading scripts: %v", err)
+		}
+		return
+	}
+
 	start := time.Now()
 
-	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	// On a terminal the progress is a status line kept below the logs
+	var statusLine *fleet.StatusLine
+	if *progressInterval > 0 && term.IsTerminal(int(os.Stdout.Fd())) {
+		statusLine = fleet.NewStatusLine(os.Stdout)
+		slog.SetDefault(slog.New(slog.NewJSONHandler(statusLine, nil)))
+	} else {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	}
 	slog.SetLogLoggerLevel(slog.LevelInfo)
 
 	if *logLevel == "debug" {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 	}
 
-	slog.Info("Command arguments", "jobID", *jobID, "accountID", *accountID, "jobIDsFile", *jobIDsFile, "customCmd", *customCmd, "sizeDistributionType", *sizeDistributionType, "baseRootDir", *baseRootDir, "concurrency", *concurrency, "maxFiles", *maxFiles, "logLevel", *logLevel)
+	slog.Info("Command arguments", "jobID", *jobID, "accountID", *accountID, "selector", *jobSelector, "inventory", *inventoryFile, "inventoryTTL", *inventoryTTL, "jobIDsFile", *jobIDsFile, "customCmd", *customCmd, "script", *scriptName, "vars", scriptVars.String(), "sizeDistributionType", *sizeDistributionType, "baseRootDir", *baseRootDir, "concurrency", *concurrency, "perNodeConcurrency", *perNodeConcurrency, "filterConcurrency", *filterConcurrency, "maxFiles", *maxFiles, "namespace", *namespace, "profile", *profName, "profilesFile", *profFile, "regions", *regions, "task", *taskName, "execUser", *execUser, "allocSelect", *allocSelect, "logLevel", *logLevel, "maxOutputBytes", *maxOutputBytes, "retryAttempts", *retryAttempts, "retryBackoff", *retryBackoff, "retryStartedExecs", *retryStartedExecs, "allocWait", *allocWait, "execTimeout", *execTimeout, "runTimeout", *runTimeout, "report", *reportFile, "reportFormat", *reportFormat, "successExitCodes", *successExitCodes, "maxFailures", *maxFailures, "maxFailureRate", *maxFailureRate, "metricsAddr", *metricsAddr, "otlpEndpoint", *otlpEndpoint, "traceFile", *traceFile, "progressInterval", *progressInterval, "checkpoint", *checkpointFile, "resume", *resumeFile, "dryRun", *dryRun, "dryRunDir", *dryRunDir, "dryRunScripts", *dryRunScripts, "outputDir", *outputDir, "outputArchive", *outputArchive)
 
-	// Create Nomad client
-	nomadClient, err := api.NewClient(api.DefaultConfig())
+	if *metricsAddr != "" {
+		server, err := metrics.Serve(*metricsAddr)
+		if err != nil {
+			log.Fatalf("Error serving metrics: %v", err)
+		}
+		slog.Info("Serving metrics", "addr", server.Addr)
+	}
+	stopTracing := setupTracing(*otlpEndpoint, *traceFile)
+	defer stopTracing()
+
+	// Cancel the run on SIGINT/SIGTERM or when the run timeout expires
+	ctx, cancel := runContext(*runTimeout)
+	defer cancel()
+	// Discovery and the run are traced under one span, ended before the spans are flushed
+	ctx, span := tracing.Start(ctx, "backup-data-gen")
+	defer span.End()
+
+	allocSelector, err := appexec.ParseAllocSelector(*allocSelect)
+	if err != nil {
+		log.Fatalf("Invalid -allocSelect: %v", err)
+	}
+	successCodes, err := fleet.ParseExitCodes(*successExitCodes)
+	if err != nil {
+		log.Fatalf("Invalid -successExitCodes: %v", err)
+	}
+	failurePolicy := fleet.FailurePolicy{MaxFailures: *maxFailures, MaxFailureRate: *maxFailureRate}
+	if *dryRunScripts != "one" && *dryRunScripts != "all" {
+		log.Fatalf("Invalid -dryRunScripts: expected one or all, got %q", *dryRunScripts)
+	}
+	if *outputArchive && *outputDir == "" {
+		log.Fatal("Invalid -outputArchive: it needs an -outputDir to archive")
+	}
+	scriptCommand, err := newScriptCommand(*scriptName, *customCmd, scriptVars)
+	if err != nil {
+		log.Fatalf("Invalid -script: %v", err)
+	}
+	sel, err := newSelector(*accountID, *jobSelector)
 	if err != nil {
-		log.Fatalf("Error creating Nomad client: %v", err)
+		log.Fatalf("Invalid -selector: %v", err)
 	}
 
-	// Create appExec that finds all the details for an app-unit to exec to run commands
-	appExec := appexec.NewAppExec(nomadClient, *concurrency)
+	// Discovery uses the inventory snapshot if there is one
+	var invFile *inventory.File
+	if *inventoryFile != "" && *jobID == "" && *jobIDsFile == "" && resumeState == nil {
+		if invFile, err = inventory.Load(*inventoryFile); err != nil {
+			log.Fatalf("Error loading inventory: %v", err)
+		}
+	}
 
-	// Determine the command to execute
-	// With a jobID specified, we can just run a single command on the app
-	if *jobID != "" {
-		if *customCmd != "" {
-			runSingleExec(appExec, *jobID, *customCmd)
-		} else {
-			backupsDataGen := datagen.NewBackupDataGen(*baseRootDir, *maxFiles, *sizeDistributionType)
-			runSingleExec(appExec, *jobID, backupsDataGen.GenerateBackupDataOnApp())
+	// Create a cluster with an appExec that finds all the details for an app-unit to exec to run commands, for each profile and region
+	clusters, err := newClusters(ctx, splitList(*profName), splitList(*regions), invFile, appexec.Options{
+		Namespace:     *namespace,
+		TaskName:      *taskName,
+		ExecUser:      *execUser,
+		AllocSelector: allocSelector,
+	})
+	if err != nil {
+		log.Fatalf("Error creating Nomad clients: %v", err)
+	}
+	if invFile != nil {
+		if err := invFile.Save(); err != nil {
+			log.Fatalf("Error saving inventory: %v", err)
 		}
-		return
 	}
 
-	// Else get job ids and run stream the command(s) to the app for each job
-	var jobs []string
-	if *jobIDsFile != "" {
+	// Get the jobs to stream the command(s) to, a job ID can be prefixed with its cluster, e.g. test-1/app-12345
+	var jobs []fleet.Job
+	if resumeState != nil {
+		// A resumed run only has the jobs of the original run that didn't succeed
+		remaining := resumeState.Remaining()
+		slog.Info("Resuming run from checkpoint", "checkpoint", *resumeFile, "startedAt", resumeState.Header.StartedAt, "numJobs", len(resumeState.Header.Jobs), "numRemaining", len(remaining))
+		if len(remaining) == 0 {
+			slog.Info("Every job of the run has already succeeded")
+			return
+		}
+		for _, id := range remaining {
+			jobs = append(jobs, fleet.ParseJob(id, clusters))
+		}
+	} else if *jobID != "" {
+		// With a jobID specified, we just run the command on that app
+		jobs = []fleet.Job{fleet.ParseJob(*jobID, clusters)}
+	} else if *jobIDsFile != "" {
 		// Read job IDs from file
 		slog.Info("Running backup data generation on jobs from file", "jobIDsFile", *jobIDsFile)
-		jobs, err = readJobIDsFromFile(*jobIDsFile)
+		jobIDs, err := readJobIDsFromFile(*jobIDsFile)
 		if err != nil {
 			log.Fatalf("Error reading job IDs from file: %v", err)
 		}
+		for _, id := range jobIDs {
+			jobs = append(jobs, fleet.ParseJob(id, clusters))
+		}
 	} else {
-		// Get jobs from account ID
-		jobs, err = appExec.GetAppJobs(*accountID)
+		// Get jobs from the account ID and selector in every cluster
+		jobs, err = fleet.DiscoverJobs(ctx, clusters, sel)
 		if err != nil {
 			log.Fatalf("Error getting app jobs: %v", err)
 		}
 		if len(jobs) == 0 {
-			log.Fatalf("No jobs found for account ID: %s", *accountID)
+			log.Fatalf("No jobs found for selector: %s", sel)
 		}
 	}
 
-	//TODO add context for signal handling
+	// Determine the command to execute, the data estimates only apply to generated data
+	// Generated data is streamed to each exec as it is generated, so it is never held in memory
 	var dataGenFunc func() string
+	var dataGenStdin func() io.Reader
+	var estimates []datagen.CategoryEstimate
 	if *customCmd != "" {
 		dataGenFunc = func() string {
 			return *customCmd
 		}
-	} else {
+	} else if scriptCommand == nil {
 		backupsDataGen := datagen.NewBackupDataGen(*baseRootDir, *maxFiles, *sizeDistributionType)
-		dataGenFunc = backupsDataGen.GenerateBackupDataOnApp
+		dataGenStdin = func() io.Reader {
+			return backupsDataGen.NewBackupDataReader()
+		}
+		estimates = backupsDataGen.Estimate()
+	}
+	runner := fleet.NewClusterRunner(clusters, dataGenFunc)
+	runner.Stdin = dataGenStdin
+	runner.TargetCommand = scriptCommand
+	runner.ExecTimeout = *execTimeout
+	runner.PerNodeConcurrency = *perNodeConcurrency
+	runner.ResolveConcurrency = *filterConcurrency
+	runner.SuccessExitCodes = successCodes
+	report := runner.Report
+
+	if *dryRun {
+		if err := runDryRun(ctx, runner, jobs, estimates, *dryRunDir, *dryRunScripts == "all"); err != nil {
+			log.Fatalf("Error in dry run: %v", err)
+		}
+		return
+	}
+
+	if runner.Checkpoint, err = openCheckpoint(*checkpointFile, *resumeFile, jobs); err != nil {
+		log.Fatalf("Error opening checkpoint: %v", err)
+	}
+	if *outputDir != "" {
+		if runner.Output, err = fleet.NewOutputDir(*outputDir); err != nil {
+			log.Fatalf("Error creating output dir: %v", err)
+		}
+	}
+	stopProgress := reportProgress(runner, *progressInterval, statusLine)
+	summary := runner.Run(ctx, jobs)
+	stopProgress()
+	if runner.Checkpoint != nil {
+		if err := runner.Checkpoint.Close(); err != nil {
+			slog.Error("Error closing checkpoint", "error", err)
+		}
+		slog.Info("Wrote checkpoint, failed and not started jobs can be retried with -resume", "checkpoint", runner.Checkpoint.Path)
+	}
+	summary.Log()
+	writeReport(report, *reportFile, *reportFormat)
+	if runner.Output != nil {
+		slog.Info("Wrote output of each job", "outputDir", runner.Output.Path)
+		if *outputArchive {
+			if path, err := runner.Output.Archive(); err != nil {
+				slog.Error("Error archiving output dir", "error", err)
+			} else {
+				slog.Info("Archived output dir", "archive", path)
+			}
+		}
 	}
-	run(appExec, jobs, dataGenFunc)
-	slog.Info(fmt.Sprintf("Completed data generation for %s type on %d jobs", *sizeDistributionType, len(jobs)))
+	slog.Info(fmt.Sprintf("Completed data generation for %s type on %d/%d jobs", *sizeDistributionType, len(summary.Finished), len(jobs)))
 	slog.Info(fmt.Sprintf("Total run time with concurrency of %d: %v", *concurrency, time.Since(start)))
+	if !checkFailures(report, failurePolicy) {
+		span.End()
+		stopTracing()
+		cancel()
+		os.Exit(1)
+	}
+}
+
+// setupTracing sets up the export of trace spans and returns the func that flushes them before exiting
+func setupTracing(otlpEndpoint, traceFile string) func() {
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{ServiceName: "backup-data-gen", OTLPEndpoint: otlpEndpoint, File: traceFile})
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Error("Error flushing trace spans", "error", err)
+		}
+	}
+}
+
+// newClusters creates a cluster for each profile, or for each region of each profile if regions are given
+// A profile's namespace applies unless -namespace is given, clusters are only named when there is more than one
+// With an inventory file, each cluster's snapshot in it is synced and used to discover jobs
+func newClusters(ctx context.Context, profNames, regions []string, invFile *inventory.File, opts appexec.Options) ([]fleet.Cluster, error) {
+	if len(profNames) == 0 {
+		profNames = []string{""}
+	}
+	if len(regions) == 0 {
+		regions = []string{""}
+	}
+
+	var clusters []fleet.Cluster
+	for _, profName := range profNames {
+		for _, region := range regions {
+			nomadConfig, prof, err := profile.LoadNomadConfig(*profFile, profName)
+			if err != nil {
+				return nil, fmt.Errorf("error loading cluster profile: %w", err)
+			}
+			if region != "" {
+				nomadConfig.Region = region
+			}
+			clusterOpts := opts
+			if prof.Namespace != "" && !isFlagSet("namespace") {
+				clusterOpts.Namespace = prof.Namespace
+			}
+
+			name := prof.Target(nomadConfig).Profile
+			if region != "" {
+				name += ":" + region
+			}
+			slog.Info("Targeting Nomad cluster", "cluster", name, "target", prof.Target(nomadConfig), "namespace", clusterOpts.Namespace)
+
+			nomadClient, err := api.NewClient(nomadConfig)
+			if err != nil {
+				return nil, fmt.Errorf("error creating Nomad client for cluster %s: %w", name, err)
+			}
+			appExec := appexec.NewAppExec(nomadClient, *concurrency, clusterOpts)
+			appExec.MaxOutputBytes = *maxOutputBytes
+			appExec.FilterConcurrency = *filterConcurrency
+			appExec.AllocWait = *allocWait
+			appExec.RetryPolicy.MaxAttempts = *retryAttempts
+			appExec.RetryPolicy.InitialBackoff = *retryBackoff
+			appExec.RetryStartedExecs = *retryStartedExecs
+			cluster := fleet.Cluster{Name: name, AppExec: appExec}
+
+			if invFile != nil {
+				inv := inventory.New(appExec.Executor, clusterOpts.Namespace)
+				inv.RetryPolicy = appExec.RetryPolicy
+				inv.InfoConcurrency = *filterConcurrency
+				cluster.Inventory, err = inv.Sync(ctx, invFile, inventory.Key(nomadConfig, clusterOpts.Namespace), *inventoryTTL)
+				if err != nil {
+					return nil, fmt.Errorf("error syncing inventory of cluster %s: %w", name, err)
+				}
+			}
+			clusters = append(clusters, cluster)
+		}
+	}
+
+	// A single cluster keeps its jobs untagged
+	if len(clusters) == 1 {
+		clusters[0].Name = ""
+	}
+	return clusters, nil
+}
+
+// newSelector selects the app jobs of an account, or the jobs matching expr and of the account if it is set
+func newSelector(accountID, expr string) (*selector.Selector, error) {
+	if expr == "" {
+		return appexec.AppJobsSelector(accountID), nil
+	}
+	sel, err := selector.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return selector.And(appexec.AccountSelector(accountID), sel), nil
+}
+
+// openCheckpoint appends to the checkpoint of a resumed run, or creates a checkpoint file with the run's
+// arguments and jobs if one is given, and returns nil if neither is
+func openCheckpoint(checkpointFile, resumeFile string, jobs []fleet.Job) (*fleet.Checkpoint, error) {
+	if resumeFile != "" {
+		return fleet.AppendCheckpoint(resumeFile)
+	}
+	if checkpointFile == "" {
+		return nil, nil
+	}
+	jobIDs := make([]string, len(jobs))
+	for i, job := range jobs {
+		jobIDs[i] = job.String()
+	}
+	return fleet.CreateCheckpoint(checkpointFile, fleet.CheckpointHeader{Args: os.Args[1:], Jobs: jobIDs})
+}
+
+// reportProgress reports the runner's progress every interval, or every second on a status line, until the returned
+// func is called, which waits for the final report
+func reportProgress(runner *fleet.Runner, interval time.Duration, statusLine *fleet.StatusLine) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+	if statusLine != nil {
+		interval = time.Second
+	}
+	runner.Progress = fleet.NewProgress()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runner.Progress.Report(ctx, interval, statusLine)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// runDryRun resolves the jobs and logs the plan with the expected data per size category, without executing anything
+// With a dir, the generated script of the first exec, or of every exec if all is set, is written to it
+func runDryRun(ctx context.Context, runner *fleet.Runner, jobs []fleet.Job, estimates []datagen.CategoryEstimate, dir string, all bool) error {
+	plan := runner.Plan(ctx, jobs)
+	plan.Log()
+	if failed := runner.Report.FailedJobIDs(); len(failed) > 0 {
+		slog.Warn("Jobs that would fail to resolve", "numJobs", len(failed), "jobIDs", failed)
+	}
+
+	var totalBytes, totalFiles int64
+	for _, e := range estimates {
+		bytes, files := e.Bytes*int64(len(plan.Targets)), e.Files*int64(len(plan.Targets))
+		slog.Info("Expected data for size category", "category", e.Name, "minFileSize", e.MinFileSize, "maxFileSize", e.MaxFileSize, "bytesPerExec", e.Bytes, "filesPerExec", e.Files, "bytes", bytes, "files", files)
+		totalBytes += bytes
+		totalFiles += files
+	}
+	if len(estimates) > 0 {
+		slog.Info("Expected data in total", "numExecs", len(plan.Targets), "bytes", totalBytes, "files", totalFiles)
+	}
+
+	if dir == "" || len(plan.Targets) == 0 {
+		return nil
+	}
+	targets := plan.Targets
+	if !all {
+		targets = targets[:1]
+	}
+	paths, err := runner.WriteScripts(ctx, targets, dir)
+	if err != nil {
+		return err
+	}
+	slog.Info("Wrote generated scripts", "dir", dir, "numScripts", len(paths), "first", paths[0])
+	return nil
+}
+
+// newScriptCommand renders the named library script for each job, or returns nil if no script is given
+// The variables are checked against the script up front so a typo fails the run before any exec
+func newScriptCommand(name, customCmd string, vars map[string]string) (func(context.Context, fleet.Target) (string, error), error) {
+	if name == "" {
+		if len(vars) > 0 {
+			return nil, fmt.Errorf("-var is only used with -script")
+		}
+		return nil, nil
+	}
+	if customCmd != "" {
+		return nil, fmt.Errorf("-script and -cmd are mutually exclusive")
+	}
+	script, err := scripts.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := script.Vars(vars)
+	if err != nil {
+		return nil, err
+	}
+	return fleet.ScriptCommand(script, resolved), nil
+}
+
+// printScripts lists the library scripts with their variables
+func printScripts(w io.Writer) error {
+	list, err := scripts.List()
+	if err != nil {
+		return err
+	}
+	for _, script := range list {
+		fmt.Fprintf(w, "%s\t%s\n", script.Name, script.Description)
+		for _, p := range script.Params {
+			spec := p.Name + " (required)"
+			if !p.Required {
+				spec = fmt.Sprintf("%s=%s", p.Name, p.Default)
+			}
+			fmt.Fprintf(w, "    -var %s\t%s\n", spec, p.Description)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma separated flag value, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// isFlagSet reports whether a flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
 // readJobIDsFromFile reads job IDs from a file, one per line
@@ -128,41 +561,55 @@ func readJobIDsFromFile(filename string) ([]string, error) {
 	return jobIDs, nil
 }
 
-func run(appExec *appexec.AppExec, jobs []string, dataGenFunc func() string) {
-	slog.Info("Running data generation on jobs", "numJobs", len(jobs))
-
-	wg := sync.WaitGroup{}
-	currentJobCount := 0
-	for _, job := range jobs {
-		currentJobCount++
-		slog.Info("Starting data generation on job", "jobID", job, "currentJobCount", currentJobCount, "totalJobs", len(jobs))
-		cmds := dataGenFunc()
-		appExec.WaitForAppExec()
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			defer appExec.ReleaseAppExec()
-			// blocking call to sync the service
-			slog.Info("Starting exec to job", "jobID", job)
-			runSingleExec(appExec, job, cmds)
-			slog.Info("Finished exec to job", "jobID", job)
-		}()
-	}
-	wg.Wait()
-}
-
-func runSingleExec(appExec *appexec.AppExec, jobID string, command string) {
-	slog.Debug(fmt.Sprintf("Executing command on job %s", jobID))
-	resp, err := appExec.ExecuteCommandOnApp(context.Background(), jobID, command)
-	if err != nil {
-		slog.Warn(fmt.Sprintf("Error executing command on job %s", jobID), "error", err)
+// runContext returns a context that is cancelled on SIGINT/SIGTERM or after runTimeout if set
+// After the first signal the default handling is restored so a second signal kills the process
+func runContext(runTimeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if runTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), runTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	// Set up signal handling for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigChan:
+			slog.Warn("Received signal, cancelling in-flight execs and not starting new jobs, signal again to force exit", "signal", sig.String())
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigChan)
+	}()
+
+	return ctx, cancel
+}
+
+// writeReport writes the run report if a report file was requested, and logs the totals
+func writeReport(report *fleet.Report, reportFile, reportFormat string) {
+	totals := report.Totals()
+	slog.Info("Run report totals", "jobs", totals.Jobs, "execs", totals.Execs, "succeeded", totals.Succeeded, "failed", totals.Failed, "errorClasses", totals.ErrorClasses, "stdinBytes", totals.StdinBytes, "outputBytes", totals.OutputBytes)
+	if reportFile == "" {
 		return
 	}
-	slog.Debug("Command executed successfully on job", "jobID", jobID, "exitCode", resp.ExitCode)
-	if resp.Stdout != "" {
-		slog.Info(fmt.Sprintf("Stdout for job %s: %s", jobID, resp.Stdout))
+	if err := report.WriteFile(reportFile, reportFormat); err != nil {
+		slog.Error("Error writing run report", "error", err)
+		return
+	}
+	slog.Info("Wrote run report", "report", reportFile)
+}
+
+// checkFailures logs the failed jobs and reports whether the run is within the failure policy
+func checkFailures(report *fleet.Report, policy fleet.FailurePolicy) bool {
+	if failed := report.FailedJobIDs(); len(failed) > 0 {
+		slog.Warn("Jobs failed", "numJobs", len(failed), "jobIDs", failed)
 	}
-	if resp.Stderr != "" {
-		slog.Error(fmt.Sprintf("Stderr for job %s: %s", jobID, resp.Stderr))
+	if err := policy.Check(report.Totals()); err != nil {
+		slog.Error("Run failed", "error", err)
+		return false
 	}
+	return true
 }
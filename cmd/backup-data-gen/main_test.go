@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/fleet"
+)
+
+func TestCheckFailures(t *testing.T) {
+	exitCode := func(code int) *int { return &code }
+	report := fleet.NewReport()
+	report.Add(
+		fleet.ReportRow{JobID: "app-1", AllocID: "app-1-alloc", ExitCode: exitCode(0)},
+		fleet.ReportRow{JobID: "app-2", AllocID: "app-2-alloc", ExitCode: exitCode(1), ErrorClass: "exit_code"},
+		fleet.ReportRow{JobID: "app-3", AllocID: "app-3-alloc", ExitCode: exitCode(0), EndTime: time.Now()},
+	)
+
+	if checkFailures(report, fleet.DefaultFailurePolicy()) {
+		t.Error("Expected the default failure policy to fail the run")
+	}
+	if !checkFailures(report, fleet.FailurePolicy{MaxFailures: 1, MaxFailureRate: 1}) {
+		t.Error("Expected the run to pass with one failure allowed")
+	}
+	if checkFailures(report, fleet.FailurePolicy{MaxFailures: -1, MaxFailureRate: 0.2}) {
+		t.Error("Expected the run to fail with a failure rate over 0.2")
+	}
+}
+
+func TestNewSelector(t *testing.T) {
+	tests := []struct {
+		accountID string
+		expr      string
+		expected  string
+	}{
+		{accountID: "", expr: "", expected: `name =~ "^app-[0-9]+$"`},
+		{accountID: "acct-1", expr: "", expected: `name =~ "^app-[0-9]+$" and meta.account_id == acct-1`},
+		{accountID: "", expr: "status == running", expected: `status == running`},
+		{accountID: "acct-1", expr: "dc == dc1 or dc == dc2", expected: `meta.account_id == acct-1 and (datacenter == dc1 or datacenter == dc2)`},
+	}
+
+	for _, tt := range tests {
+		sel, err := newSelector(tt.accountID, tt.expr)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tt.expr, err)
+			continue
+		}
+		if sel.String() != tt.expected {
+			t.Errorf("Expected selector %s, got %s", tt.expected, sel)
+		}
+	}
+	if _, err := newSelector("", "status =="); err == nil {
+		t.Error("Expected an error for an invalid selector")
+	}
+}
+
+func TestNewScriptCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		customCmd string
+		vars      map[string]string
+		expectErr bool
+	}{
+		{name: "", vars: nil},
+		{name: "", vars: map[string]string{"path": "."}, expectErr: true},
+		{name: "disk-usage", vars: map[string]string{"path": "./uploads"}},
+		{name: "disk-usage", customCmd: "ls", expectErr: true},
+		{name: "disk-usage", vars: map[string]string{"depth": "2"}, expectErr: true},
+		{name: "missing", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		command, err := newScriptCommand(tt.name, tt.customCmd, tt.vars)
+		if tt.expectErr != (err != nil) {
+			t.Errorf("Expected error %v for script %q with vars %v, got %v", tt.expectErr, tt.name, tt.vars, err)
+		}
+		if err == nil && (command != nil) != (tt.name != "") {
+			t.Errorf("Expected a command only for a script, got %v for %q", command != nil, tt.name)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{input: "", expected: nil},
+		{input: "test-1", expected: []string{"test-1"}},
+		{input: " test-1, ,test-2 ", expected: []string{"test-1", "test-2"}},
+	}
+
+	for _, tt := range tests {
+		items := splitList(tt.input)
+		if len(items) != len(tt.expected) {
+			t.Errorf("Expected %q to split into %v, got %v", tt.input, tt.expected, items)
+			continue
+		}
+		for i := range items {
+			if items[i] != tt.expected[i] {
+				t.Errorf("Expected %q to split into %v, got %v", tt.input, tt.expected, items)
+			}
+		}
+	}
+}
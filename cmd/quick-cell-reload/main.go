@@ -9,10 +9,19 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"regexp"
 	"syscall"
 	"time"
 
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/inventory"
 	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/jobmeta"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/metrics"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/profile"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/selector"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/tracing"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/time/rate"
 )
 
@@ -23,15 +32,58 @@ const (
 
 func main() {
 	var (
-		jobID      = flag.String("job", "", "Job ID to update")
-		namespace  = flag.String("namespace", defaultNamespace, "Nomad namespace")
-		jobPattern = flag.String("pattern", "", "Job pattern for updating multiple jobs")
-		burst      = flag.Int("burst", 10, "Number of requests allowed in burst")
-		limit      = flag.Int("limit", 1, "Number of requests allowed per interval")
-		interval   = flag.Duration("interval", 1*time.Second, "Time interval for rate limiting")
+		jobID        = flag.String("job", "", "Job ID to update")
+		namespace    = flag.String("namespace", defaultNamespace, "Nomad namespace")
+		jobPattern   = flag.String("pattern", "", "Job pattern for updating multiple jobs")
+		selectExpr   = flag.String("selector", "", "Selector for updating multiple jobs instead of a pattern, e.g. 'meta.tier == gold and status == running'")
+		burst        = flag.Int("burst", 10, "Number of requests allowed in burst")
+		limit        = flag.Int("limit", 1, "Number of requests allowed per interval")
+		interval     = flag.Duration("interval", 1*time.Second, "Time interval for rate limiting")
+		wait         = flag.Duration("wait", 0, "How long to wait for each reloaded job's app-unit to be running at the new job version, 0 to not wait")
+		invFile      = flag.String("inventory", "", "Inventory snapshot file to select jobs from instead of listing them on the cluster, created if missing")
+		invTTL       = flag.Duration("inventoryTTL", inventory.DefaultTTL, "Age after which the inventory snapshot is refreshed incrementally before selecting jobs")
+		metricsAddr  = flag.String("metricsAddr", "", "Address to serve Prometheus metrics on at /metrics while updating, e.g. :9100")
+		otlpEndpoint = flag.String("otlpEndpoint", "", "OTLP/HTTP collector URL to export trace spans of the updates to, e.g. http://localhost:4318")
+		traceFile    = flag.String("traceFile", "", "File to write trace spans of the updates to as JSON, for offline inspection")
+		profName     = flag.String("profile", "", "Cluster profile to target, the Nomad client ENV vars are used if not set")
+		profFile     = flag.String("profilesFile", profile.DefaultPath(), "File of cluster profiles")
 	)
 	flag.Parse()
 
+	var sel *selector.Selector
+	if *selectExpr != "" {
+		if *jobPattern != "" {
+			log.Fatal("Only one of -pattern or -selector can be given")
+		}
+		var err error
+		if sel, err = selector.Parse(*selectExpr); err != nil {
+			log.Fatalf("Invalid -selector: %v", err)
+		}
+	}
+
+	if *metricsAddr != "" {
+		server, err := metrics.Serve(*metricsAddr)
+		if err != nil {
+			log.Fatalf("Failed to serve metrics: %v", err)
+		}
+		log.Printf("Serving metrics on %s", server.Addr)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{ServiceName: "quick-cell-reload", OTLPEndpoint: *otlpEndpoint, File: *traceFile})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	nomadConfig, prof, err := profile.LoadNomadConfig(*profFile, *profName)
+	if err != nil {
+		log.Fatalf("Failed to load cluster profile: %v", err)
+	}
+	// The profile's namespace applies unless -namespace is given
+	if prof.Namespace != "" && !isFlagSet("namespace") {
+		*namespace = prof.Namespace
+	}
+	log.Printf("Targeting Nomad cluster %s", prof.Target(nomadConfig))
+
 	// Create rate limiter for multiple job updates
 	var limiter *rate.Limiter
 	if *jobID == "" {
@@ -39,10 +91,11 @@ func main() {
 		limiter = jobmeta.NewRateLimiter(*burst, *limit, *interval)
 	}
 
-	updater, err := jobmeta.NewUpdater(limiter)
+	updater, err := jobmeta.NewUpdater(nomadConfig, limiter)
 	if err != nil {
 		log.Fatalf("Failed to create job meta updater: %v", err)
 	}
+	updater.WaitTimeout = *wait
 
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -58,14 +111,34 @@ func main() {
 		cancel()
 	}()
 
-	if err := runCommand(ctx, updater, jobID, namespace, jobPattern); err != nil {
+	// The whole reload is traced under one span, flushed before exiting
+	ctx, span := tracing.Start(ctx, "quick-cell-reload", attribute.String("namespace", *namespace))
+
+	// Select jobs from the inventory snapshot if there is one
+	var snap *inventory.Snapshot
+	if *invFile != "" && *jobID == "" {
+		if snap, err = syncInventory(ctx, *invFile, *invTTL, nomadConfig, *namespace); err != nil {
+			err = fmt.Errorf("failed to sync inventory: %w", err)
+		}
+	}
+	if err == nil {
+		err = runCommand(ctx, updater, jobID, namespace, jobPattern, sel, snap)
+	}
+
+	tracing.End(span, err)
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer flushCancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Printf("Failed to flush trace spans: %v", err)
+	}
+	if err != nil {
 		log.Fatalf("Command failed: %v", err)
 	}
 
 	log.Println("")
 }
 
-func runCommand(ctx context.Context, updater *jobmeta.Updater, jobID, namespace *string, jobPattern *string) error {
+func runCommand(ctx context.Context, updater *jobmeta.Updater, jobID, namespace *string, jobPattern *string, sel *selector.Selector, snap *inventory.Snapshot) error {
 
 	// Generate a new reload hash
 	reloadHash, err := generateReloadHash()
@@ -77,7 +150,20 @@ func runCommand(ctx context.Context, updater *jobmeta.Updater, jobID, namespace
 		"reload-hash": reloadHash,
 	}
 
-	// If no job ID is provided, use the pattern (defaulting to "app-" if no pattern specified)
+	// If no job ID is provided, use the selector or the pattern (defaulting to "app-" if no pattern specified)
+	if *jobID == "" && snap != nil {
+		if sel == nil {
+			pattern := *jobPattern
+			if pattern == "" {
+				pattern = defaultPattern
+			}
+			sel = selector.MustParse(fmt.Sprintf("id =~ %q", "^"+regexp.QuoteMeta(pattern)))
+		}
+		return updater.UpdateJobs(ctx, *namespace, snap.Select(sel), metaUpdates)
+	}
+	if *jobID == "" && sel != nil {
+		return updater.UpdateSelectedJobs(ctx, *namespace, sel, metaUpdates)
+	}
 	if *jobID == "" {
 		pattern := *jobPattern
 		if pattern == "" {
@@ -86,8 +172,41 @@ func runCommand(ctx context.Context, updater *jobmeta.Updater, jobID, namespace
 		return updater.UpdateMultipleJobs(ctx, *namespace, pattern, metaUpdates)
 	}
 
-	updater.UpdateJobMeta(ctx, *jobID, *namespace, metaUpdates)
-	return nil
+	// Update errors are logged by the updater, as for multiple jobs
+	version, err := updater.UpdateJobMeta(ctx, *jobID, *namespace, metaUpdates)
+	if err != nil || updater.WaitTimeout <= 0 {
+		return nil
+	}
+	return updater.WaitForJob(ctx, *jobID, *namespace, version)
+}
+
+// syncInventory loads the inventory file, syncs the namespace's snapshot in it and saves it
+func syncInventory(ctx context.Context, path string, ttl time.Duration, nomadConfig *api.Config, namespace string) (*inventory.Snapshot, error) {
+	f, err := inventory.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	client, err := api.NewClient(nomadConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Nomad client: %w", err)
+	}
+	inv := inventory.New(appexec.NewNomadExecutor(client), namespace)
+	snap, err := inv.Sync(ctx, f, inventory.Key(nomadConfig, namespace), ttl)
+	if err != nil {
+		return nil, err
+	}
+	return snap, f.Save()
+}
+
+// isFlagSet reports whether a flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
 func generateReloadHash() (string, error) {
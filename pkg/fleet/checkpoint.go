@@ -0,0 +1,181 @@
+package fleet
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckpointVersion is the version of the checkpoint file format
+const CheckpointVersion = 1
+
+// Job outcomes recorded in a checkpoint, a job with no outcome was never started
+const (
+	CheckpointSucceeded = "succeeded"
+	CheckpointFailed    = "failed"
+	CheckpointCancelled = "cancelled" // cancelled while in flight
+)
+
+// CheckpointHeader is the first line of a checkpoint file, with what a resumed run needs to repeat the run
+type CheckpointHeader struct {
+	Version   int       `json:"version"`
+	StartedAt time.Time `json:"started_at"`
+	Args      []string  `json:"args"` // command line arguments of the run
+	Jobs      []string  `json:"jobs"` // jobs of the run, prefixed with their cluster if they have one
+}
+
+// CheckpointEntry is the outcome of one job, appended to the checkpoint file when the job is done
+type CheckpointEntry struct {
+	Job     string    `json:"job"`
+	Outcome string    `json:"outcome"`
+	Time    time.Time `json:"time"`
+}
+
+// Checkpoint appends the outcome of each job of a run to a file as it completes, so a run that dies can be resumed
+type Checkpoint struct {
+	Path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// CreateCheckpoint creates a checkpoint file, replacing any file at path, and writes its header
+func CreateCheckpoint(path string, header CheckpointHeader) (*Checkpoint, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating checkpoint file %s: %w", path, err)
+	}
+	header.Version = CheckpointVersion
+	if header.StartedAt.IsZero() {
+		header.StartedAt = time.Now()
+	}
+	c := &Checkpoint{Path: path, file: file}
+	if err := c.writeLine(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// AppendCheckpoint opens an existing checkpoint file to append the outcomes of a resumed run to
+func AppendCheckpoint(path string) (*Checkpoint, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening checkpoint file %s: %w", path, err)
+	}
+	return &Checkpoint{Path: path, file: file}, nil
+}
+
+// Record appends the outcome of a job, safe for concurrent use
+func (c *Checkpoint) Record(job, outcome string) error {
+	return c.writeLine(CheckpointEntry{Job: job, Outcome: outcome, Time: time.Now()})
+}
+
+// writeLine writes v as a single JSON line, in one write so a crash leaves at most a partial last line
+func (c *Checkpoint) writeLine(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoint: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing checkpoint file %s: %w", c.Path, err)
+	}
+	return nil
+}
+
+// Close closes the checkpoint file
+func (c *Checkpoint) Close() error {
+	return c.file.Close()
+}
+
+// CheckpointState is a checkpoint file read back, with the last outcome of each job
+type CheckpointState struct {
+	Header   CheckpointHeader
+	Outcomes map[string]string
+}
+
+// LoadCheckpoint reads a checkpoint file, ignoring a partial last line left by a run that died mid-write
+func LoadCheckpoint(path string) (*CheckpointState, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening checkpoint file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// The header lists every job of the run, so it can be far longer than the default token size
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading checkpoint file %s: %w", path, err)
+		}
+		return nil, fmt.Errorf("checkpoint file %s is empty", path)
+	}
+	state := &CheckpointState{Outcomes: map[string]string{}}
+	if err := json.Unmarshal(scanner.Bytes(), &state.Header); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint header in %s: %w", path, err)
+	}
+	if state.Header.Version != CheckpointVersion {
+		return nil, fmt.Errorf("checkpoint file %s has version %d, expected %d", path, state.Header.Version, CheckpointVersion)
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		var entry CheckpointEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Only the last line can be partial, anything else is a corrupt file
+			if scanner.Scan() {
+				return nil, fmt.Errorf("error parsing checkpoint file %s line %d: %w", path, line, err)
+			}
+			break
+		}
+		state.Outcomes[entry.Job] = entry.Outcome
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading checkpoint file %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// Remaining returns the jobs of the run that didn't succeed, in the order of the run
+// A job given without a cluster in a multi-cluster run is recorded per cluster, so only the clusters it
+// didn't succeed in remain, or the untagged job if it has no outcome in any cluster
+func (s *CheckpointState) Remaining() []string {
+	var remaining []string
+	for _, job := range s.Header.Jobs {
+		if outcome, ok := s.Outcomes[job]; ok {
+			if outcome != CheckpointSucceeded {
+				remaining = append(remaining, job)
+			}
+			continue
+		}
+		if strings.Contains(job, "/") {
+			remaining = append(remaining, job)
+			continue
+		}
+
+		// A cluster the job succeeded in still counts, so the job isn't rerun everywhere
+		found := false
+		var tagged []string
+		for key, outcome := range s.Outcomes {
+			if strings.HasSuffix(key, "/"+job) {
+				found = true
+				if outcome != CheckpointSucceeded {
+					tagged = append(tagged, key)
+				}
+			}
+		}
+		if !found {
+			remaining = append(remaining, job)
+			continue
+		}
+		sort.Strings(tagged)
+		remaining = append(remaining, tagged...)
+	}
+	return remaining
+}
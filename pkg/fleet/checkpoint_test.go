@@ -0,0 +1,109 @@
+package fleet
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+func TestRunnerCheckpoint(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	for _, job := range []string{"app-1", "app-2", "app-3"} {
+		fake.AddAppJob(job, nil)
+	}
+	fake.Results["app-2"] = appexec.FakeExecResult{Err: errors.New("exec failed")}
+
+	path := filepath.Join(t.TempDir(), "run.checkpoint")
+	jobs := []string{"app-1", "app-2", "app-missing", "app-3", "app-4"}
+	checkpoint, err := CreateCheckpoint(path, CheckpointHeader{Args: []string{"-size", "large"}, Jobs: jobs})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// app-4 is left out as if the run died before it started
+	appExec := appexec.NewAppExecWithExecutor(fake, 2, appexec.Options{})
+	runner := NewRunner(appExec, func() string { return "echo hello" })
+	runner.Checkpoint = checkpoint
+	runner.Run(context.Background(), Jobs(jobs[:4]))
+	if err := checkpoint.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	state, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(state.Header.Args) != 2 || state.Header.Args[1] != "large" {
+		t.Errorf("Expected the run's args in the header, got %v", state.Header.Args)
+	}
+	expected := map[string]string{"app-1": CheckpointSucceeded, "app-2": CheckpointFailed, "app-missing": CheckpointFailed, "app-3": CheckpointSucceeded}
+	for job, outcome := range expected {
+		if state.Outcomes[job] != outcome {
+			t.Errorf("Expected %s to be %s, got %q", job, outcome, state.Outcomes[job])
+		}
+	}
+	if remaining := strings.Join(state.Remaining(), ","); remaining != "app-2,app-missing,app-4" {
+		t.Errorf("Expected app-2, app-missing and app-4 to remain, got %s", remaining)
+	}
+
+	// A resumed run appends to the file, and the last outcome of a job wins
+	checkpoint, err = AppendCheckpoint(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := checkpoint.Record("app-2", CheckpointSucceeded); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkpoint.Close()
+	if state, err = LoadCheckpoint(path); err != nil || state.Outcomes["app-2"] != CheckpointSucceeded {
+		t.Errorf("Expected app-2 to have succeeded after the resume, got %v and error %v", state.Outcomes["app-2"], err)
+	}
+}
+
+func TestLoadCheckpoint(t *testing.T) {
+	header := `{"version":1,"args":[],"jobs":["app-1","app-2","app-3"]}`
+	tests := []struct {
+		name      string
+		contents  string
+		remaining string
+		expectErr bool
+	}{
+		{name: "partial last line", contents: header + "\n" + `{"job":"app-1","outcome":"succeeded"}` + "\n" + `{"job":"app-2","outc`, remaining: "app-2,app-3"},
+		{name: "corrupt line", contents: header + "\n" + `{"job":` + "\n" + `{"job":"app-1","outcome":"succeeded"}` + "\n", expectErr: true},
+		{name: "other version", contents: `{"version":2}` + "\n", expectErr: true},
+		{name: "empty", contents: "", expectErr: true},
+		{
+			name: "untagged job in several clusters",
+			contents: header + "\n" + `{"job":"test-1/app-1","outcome":"succeeded"}` + "\n" + `{"job":"test-2/app-1","outcome":"failed"}` + "\n" +
+				`{"job":"test-1/app-2","outcome":"succeeded"}` + "\n",
+			remaining: "test-2/app-1,app-3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "run.checkpoint")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			state, err := LoadCheckpoint(path)
+			if tt.expectErr {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if remaining := strings.Join(state.Remaining(), ","); remaining != tt.remaining {
+				t.Errorf("Expected %s to remain, got %s", tt.remaining, remaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package fleet
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/inventory"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/selector"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+// Cluster is a Nomad cluster or region to run on, each with its own AppExec and so its own exec concurrency
+type Cluster struct {
+	Name      string
+	AppExec   *appexec.AppExec
+	Inventory *inventory.Snapshot // snapshot to discover jobs and their allocations from instead of the cluster, if set
+}
+
+// appUnitAllocs gets the selected allocations of a job, from the inventory snapshot if there is one
+// Allocations on nodes the snapshot has as down or draining are left out, and a job with no running allocations
+// left in the snapshot is looked up on the cluster, as they may have started since the snapshot was refreshed
+// Each target's allocation is checked on the cluster again before its exec, so stopped ones are still caught
+func (c *Cluster) appUnitAllocs(ctx context.Context, jobID string) ([]*api.AllocationListStub, error) {
+	if c.Inventory != nil {
+		var stubs []*api.AllocationListStub
+		for _, alloc := range c.Inventory.JobAllocs(jobID) {
+			if c.Inventory.NodeReady(alloc.NodeID) {
+				stubs = append(stubs, alloc.Stub())
+			}
+		}
+		if allocs, err := c.AppExec.SelectAppUnitAllocs(jobID, stubs); err == nil {
+			return allocs, nil
+		}
+	}
+	return c.AppExec.GetAppUnitAllocs(ctx, jobID)
+}
+
+// Job is a Nomad job tagged with the cluster it came from
+type Job struct {
+	Cluster string // empty to look for the job in every cluster
+	ID      string
+}
+
+// String is the job ID, prefixed with its cluster and a slash if it has one
+func (j Job) String() string {
+	if j.Cluster == "" {
+		return j.ID
+	}
+	return j.Cluster + "/" + j.ID
+}
+
+// Jobs creates untagged jobs from job IDs
+func Jobs(jobIDs []string) []Job {
+	jobs := make([]Job, len(jobIDs))
+	for i, id := range jobIDs {
+		jobs[i] = Job{ID: id}
+	}
+	return jobs
+}
+
+// ParseJob parses a job as "<cluster>/<jobID>" if the prefix is one of the clusters, else as an untagged job ID
+func ParseJob(s string, clusters []Cluster) Job {
+	if name, id, ok := strings.Cut(s, "/"); ok {
+		for _, c := range clusters {
+			if c.Name == name {
+				return Job{Cluster: name, ID: id}
+			}
+		}
+	}
+	return Job{ID: s}
+}
+
+// DiscoverJobs finds the jobs matching a selector in every cluster in parallel, tagged with their cluster
+// Clusters with an inventory snapshot are matched against the snapshot without calling the cluster
+func DiscoverJobs(ctx context.Context, clusters []Cluster, sel *selector.Selector) ([]Job, error) {
+	found := make([][]string, len(clusters))
+	errs := make([]error, len(clusters))
+	wg := sync.WaitGroup{}
+	for i, c := range clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.Inventory != nil {
+				found[i] = c.Inventory.Select(sel)
+				return
+			}
+			found[i], errs[i] = c.AppExec.GetJobs(ctx, sel)
+		}()
+	}
+	wg.Wait()
+
+	var jobs []Job
+	for i, c := range clusters {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("error getting jobs from cluster %s: %w", c.Name, errs[i])
+		}
+		if len(clusters) > 1 {
+			slog.Info("Found jobs in cluster", "cluster", c.Name, "numJobs", len(found[i]))
+		}
+		for _, id := range found[i] {
+			jobs = append(jobs, Job{Cluster: c.Name, ID: id})
+		}
+	}
+	return jobs, nil
+}
@@ -0,0 +1,153 @@
+package fleet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/inventory"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+func testClusters(concurrency int) ([]Cluster, *appexec.FakeExecutor, *appexec.FakeExecutor) {
+	west := appexec.NewFakeExecutor()
+	east := appexec.NewFakeExecutor()
+	clusters := []Cluster{
+		{Name: "test-1:us-west", AppExec: appexec.NewAppExecWithExecutor(west, concurrency, appexec.Options{})},
+		{Name: "test-1:us-east", AppExec: appexec.NewAppExecWithExecutor(east, concurrency, appexec.Options{})},
+	}
+	return clusters, west, east
+}
+
+func TestParseJob(t *testing.T) {
+	clusters, _, _ := testClusters(1)
+	tests := []struct {
+		input    string
+		expected Job
+	}{
+		{input: "app-1", expected: Job{ID: "app-1"}},
+		{input: "test-1:us-east/app-1", expected: Job{Cluster: "test-1:us-east", ID: "app-1"}},
+		{input: "unknown/app-1", expected: Job{ID: "unknown/app-1"}},
+	}
+
+	for _, tt := range tests {
+		if job := ParseJob(tt.input, clusters); job != tt.expected {
+			t.Errorf("Expected %q to parse to %+v, got %+v", tt.input, tt.expected, job)
+		}
+		if job := ParseJob(tt.input, clusters); job.String() != tt.input {
+			t.Errorf("Expected %q to round trip, got %s", tt.input, job.String())
+		}
+	}
+}
+
+func TestDiscoverJobs(t *testing.T) {
+	clusters, west, east := testClusters(1)
+	west.AddAppJob("app-1", map[string]string{appexec.AccountIDMetaKey: "acct-1"})
+	west.AddAppJob("app-2", map[string]string{appexec.AccountIDMetaKey: "acct-2"})
+	east.AddAppJob("app-3", map[string]string{appexec.AccountIDMetaKey: "acct-1"})
+	east.AddAppJob("other-job", map[string]string{appexec.AccountIDMetaKey: "acct-1"})
+
+	jobs, err := DiscoverJobs(context.Background(), clusters, appexec.AppJobsSelector("acct-1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []Job{{Cluster: "test-1:us-west", ID: "app-1"}, {Cluster: "test-1:us-east", ID: "app-3"}}
+	if len(jobs) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, jobs)
+	}
+	for i := range expected {
+		if jobs[i] != expected[i] {
+			t.Errorf("Expected job %d to be %+v, got %+v", i, expected[i], jobs[i])
+		}
+	}
+}
+
+func TestDiscoverJobsInventory(t *testing.T) {
+	clusters, west, east := testClusters(1)
+	west.AddAppJob("app-1", map[string]string{appexec.AccountIDMetaKey: "acct-1"})
+	east.AddAppJob("app-2", map[string]string{appexec.AccountIDMetaKey: "acct-1"})
+	snap := inventory.NewSnapshot(appexec.SitesNamespace)
+	if _, err := inventory.New(west, appexec.SitesNamespace).Refresh(context.Background(), snap); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	clusters[0].Inventory = snap
+	west.AddAppJob("app-3", map[string]string{appexec.AccountIDMetaKey: "acct-1"}) // not in the snapshot yet
+	westListCalls := west.MethodCalls["ListJobs"]
+
+	jobs, err := DiscoverJobs(context.Background(), clusters, appexec.AppJobsSelector("acct-1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []Job{{Cluster: "test-1:us-west", ID: "app-1"}, {Cluster: "test-1:us-east", ID: "app-2"}}
+	if len(jobs) != len(expected) || jobs[0] != expected[0] || jobs[1] != expected[1] {
+		t.Errorf("Expected %v, got %v", expected, jobs)
+	}
+	if west.MethodCalls["ListJobs"] != westListCalls {
+		t.Error("Expected no job list calls to the cluster with a snapshot")
+	}
+}
+
+func TestRunnerClusters(t *testing.T) {
+	clusters, west, east := testClusters(1)
+	for _, fake := range []*appexec.FakeExecutor{west, east} {
+		fake.AddAppJobOnNode("app-1", "node-1", nil)
+		fake.AddAppJobOnNode("app-2", "node-2", nil)
+		fake.ExecDelay = 20 * time.Millisecond
+	}
+	east.AddAppJob("app-east", nil)
+
+	runner := NewClusterRunner(clusters, func() string { return "echo hello" })
+	jobs := []Job{
+		{Cluster: "test-1:us-west", ID: "app-1"},
+		{Cluster: "test-1:us-west", ID: "app-2"},
+		{Cluster: "test-1:us-east", ID: "app-1"},
+		{Cluster: "test-1:us-east", ID: "app-2"},
+		{ID: "app-east"}, // untagged, only found in us-east
+	}
+	summary := runner.Run(context.Background(), jobs)
+
+	if len(summary.Finished) != len(jobs) {
+		t.Errorf("Expected all jobs finished, got %+v", summary)
+	}
+	if len(west.ExecCalls()) != 2 || len(east.ExecCalls()) != 3 {
+		t.Errorf("Expected 2 execs in us-west and 3 in us-east, got %d and %d", len(west.ExecCalls()), len(east.ExecCalls()))
+	}
+	// Each cluster has a concurrency of 1 across its nodes
+	for name, fake := range map[string]*appexec.FakeExecutor{"us-west": west, "us-east": east} {
+		for node, max := range fake.MaxInFlight {
+			if max > 1 {
+				t.Errorf("Expected at most 1 concurrent exec in %s, got %d on %s", name, max, node)
+			}
+		}
+	}
+
+	totals := runner.Report.Totals()
+	if totals.Jobs != 5 || totals.Succeeded != 5 {
+		t.Errorf("Expected 5 succeeded jobs in the combined report, got %+v", totals)
+	}
+	for _, row := range runner.Report.Rows() {
+		if row.Cluster == "" {
+			t.Errorf("Expected every row tagged with its cluster, got %+v", row)
+		}
+		if row.JobID == "app-east" && row.Cluster != "test-1:us-east" {
+			t.Errorf("Expected app-east in us-east, got %s", row.Cluster)
+		}
+	}
+}
+
+func TestRunnerClustersJobNotFound(t *testing.T) {
+	clusters, _, _ := testClusters(1)
+	runner := NewClusterRunner(clusters, func() string { return "echo hello" })
+	runner.Run(context.Background(), []Job{{ID: "app-missing"}, {Cluster: "test-2", ID: "app-1"}})
+
+	rows := runner.Report.Rows()
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 error rows, got %+v", rows)
+	}
+	for _, row := range rows {
+		if !row.Failed() {
+			t.Errorf("Expected %s to fail, got %+v", row.Job(), row)
+		}
+	}
+}
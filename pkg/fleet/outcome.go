@@ -0,0 +1,97 @@
+package fleet
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExitCodes is a set of remote exit codes that count as success
+// A nil set counts only exit code 0 as success
+type ExitCodes map[int]bool
+
+// ParseExitCodes parses a comma separated list of exit codes and ranges, e.g. "0,3,10-12"
+func ParseExitCodes(s string) (ExitCodes, error) {
+	codes := ExitCodes{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		low, high, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(low)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exit code %q", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(high); err != nil || to < from {
+				return nil, fmt.Errorf("invalid exit code range %q", part)
+			}
+		}
+		if from < 0 || to > 255 {
+			return nil, fmt.Errorf("exit code %q out of range 0-255", part)
+		}
+		for code := from; code <= to; code++ {
+			codes[code] = true
+		}
+	}
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("no exit codes in %q", s)
+	}
+	return codes, nil
+}
+
+// Success reports whether an exit code counts as success
+func (c ExitCodes) Success(code int) bool {
+	if c == nil {
+		return code == 0
+	}
+	return c[code]
+}
+
+func (c ExitCodes) String() string {
+	if c == nil {
+		return "0"
+	}
+	codes := make([]int, 0, len(c))
+	for code := range c {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = strconv.Itoa(code)
+	}
+	return strings.Join(parts, ",")
+}
+
+// FailurePolicy decides whether a run as a whole failed from its failed jobs
+// A job failed if any of its rows failed, including jobs that were never started
+type FailurePolicy struct {
+	MaxFailures    int     // failed jobs allowed before the run fails, negative for no limit
+	MaxFailureRate float64 // fraction of failed jobs allowed before the run fails, 1 for no limit
+}
+
+// DefaultFailurePolicy fails a run on any failed job
+func DefaultFailurePolicy() FailurePolicy {
+	return FailurePolicy{
+		MaxFailures:    0,
+		MaxFailureRate: 1,
+	}
+}
+
+// Check returns an error if the failed jobs in totals exceed either threshold
+func (p FailurePolicy) Check(totals ReportTotals) error {
+	if p.MaxFailures >= 0 && totals.FailedJobs > p.MaxFailures {
+		return fmt.Errorf("%d of %d jobs failed, more than the %d allowed", totals.FailedJobs, totals.Jobs, p.MaxFailures)
+	}
+	if totals.Jobs > 0 {
+		rate := float64(totals.FailedJobs) / float64(totals.Jobs)
+		if rate > p.MaxFailureRate {
+			return fmt.Errorf("%d of %d jobs failed, a failure rate of %.3f is more than the %.3f allowed", totals.FailedJobs, totals.Jobs, rate, p.MaxFailureRate)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package fleet
+
+import (
+	"testing"
+)
+
+func TestParseExitCodes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{input: "0", expected: "0"},
+		{input: "0,3", expected: "0,3"},
+		{input: " 10-12, 0 ", expected: "0,10,11,12"},
+		{input: "", wantErr: true},
+		{input: "abc", wantErr: true},
+		{input: "5-2", wantErr: true},
+		{input: "256", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		codes, err := ParseExitCodes(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Expected error for %q, got %v", tt.input, codes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tt.input, err)
+			continue
+		}
+		if codes.String() != tt.expected {
+			t.Errorf("Expected %q to parse to %s, got %s", tt.input, tt.expected, codes.String())
+		}
+	}
+}
+
+func TestExitCodesSuccessDefault(t *testing.T) {
+	var codes ExitCodes
+	if !codes.Success(0) || codes.Success(1) {
+		t.Error("Expected a nil set to count only exit code 0 as success")
+	}
+}
+
+func TestFailurePolicyCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     FailurePolicy
+		jobs       int
+		failedJobs int
+		wantErr    bool
+	}{
+		{name: "default no failures", policy: DefaultFailurePolicy(), jobs: 10, failedJobs: 0},
+		{name: "default one failure", policy: DefaultFailurePolicy(), jobs: 10, failedJobs: 1, wantErr: true},
+		{name: "within max failures", policy: FailurePolicy{MaxFailures: 2, MaxFailureRate: 1}, jobs: 10, failedJobs: 2},
+		{name: "over max failures", policy: FailurePolicy{MaxFailures: 2, MaxFailureRate: 1}, jobs: 10, failedJobs: 3, wantErr: true},
+		{name: "within max rate", policy: FailurePolicy{MaxFailures: -1, MaxFailureRate: 0.1}, jobs: 100, failedJobs: 10},
+		{name: "over max rate", policy: FailurePolicy{MaxFailures: -1, MaxFailureRate: 0.1}, jobs: 100, failedJobs: 11, wantErr: true},
+		{name: "no limits", policy: FailurePolicy{MaxFailures: -1, MaxFailureRate: 1}, jobs: 10, failedJobs: 10},
+		{name: "no jobs", policy: DefaultFailurePolicy(), jobs: 0, failedJobs: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(ReportTotals{Jobs: tt.jobs, FailedJobs: tt.failedJobs})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package fleet
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+// OutputMeta is the meta.json written next to an exec's stdout and stderr
+type OutputMeta struct {
+	ReportRow
+	Truncated bool `json:"output_truncated"` // stdout or stderr was cut at the AppExec's MaxOutputBytes
+}
+
+// OutputDir writes the output of each exec to <dir>/[<cluster>/]<jobID>/stdout, stderr and meta.json
+// A job that execs on several allocations gets a directory per allocation under its own, named by the short alloc ID
+// A nil OutputDir writes nothing, so the runner can call it unconditionally
+type OutputDir struct {
+	Path string
+
+	mu       sync.Mutex
+	perAlloc map[string]bool // jobs with several targets in the run
+}
+
+// NewOutputDir creates the output directory if it doesn't exist
+func NewOutputDir(path string) (*OutputDir, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating output dir: %w", err)
+	}
+	return &OutputDir{Path: path, perAlloc: map[string]bool{}}, nil
+}
+
+// setTargets records which jobs of a run exec on more than one allocation
+func (o *OutputDir) setTargets(targets []Target) {
+	if o == nil {
+		return
+	}
+	counts := map[string]int{}
+	for _, t := range targets {
+		counts[t.Job.String()]++
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for job, n := range counts {
+		o.perAlloc[job] = n > 1
+	}
+}
+
+// dir returns the directory of a target's output
+func (o *OutputDir) dir(target Target) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	dir := filepath.Join(o.Path, target.Job.Cluster, target.Job.ID)
+	if o.perAlloc[target.Job.String()] {
+		dir = filepath.Join(dir, shortID(target.Alloc.ID))
+	}
+	return dir
+}
+
+// write writes the output of a target's exec with its report row as the meta, resp is nil if the exec
+// never returned, leaving only the meta
+func (o *OutputDir) write(target Target, row ReportRow, resp *appexec.ExecResponse) error {
+	if o == nil {
+		return nil
+	}
+	dir := o.dir(target)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("error creating output dir of job %s: %w", target.Job, err)
+	}
+
+	meta := OutputMeta{ReportRow: row}
+	if resp != nil {
+		meta.Truncated = resp.Truncated
+		if err := os.WriteFile(filepath.Join(dir, "stdout"), []byte(resp.Stdout), 0o644); err != nil {
+			return fmt.Errorf("error writing stdout of job %s: %w", target.Job, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "stderr"), []byte(resp.Stderr), 0o644); err != nil {
+			return fmt.Errorf("error writing stderr of job %s: %w", target.Job, err)
+		}
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "meta.json"), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing meta of job %s: %w", target.Job, err)
+	}
+	return nil
+}
+
+// Archive writes the output directory to <dir>.tar.gz, with the directory as the top level entry, and returns its path
+func (o *OutputDir) Archive() (string, error) {
+	path := filepath.Clean(o.Path) + ".tar.gz"
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("error creating archive: %w", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	base := filepath.Dir(filepath.Clean(o.Path))
+	err = filepath.WalkDir(o.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if d.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error archiving output dir: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return "", fmt.Errorf("error archiving output dir: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("error archiving output dir: %w", err)
+	}
+	return path, file.Close()
+}
@@ -0,0 +1,133 @@
+package fleet
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+func TestRunnerOutputDir(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	fake.AddAppJob("app-2", nil)
+	fake.AddAppJob("app-3", nil)
+	running := map[string]*api.TaskState{appexec.AppUnitTaskName: {State: "running"}}
+	fake.Allocs["app-2"] = []*api.AllocationListStub{
+		{ID: "a2222222-alloc", JobID: "app-2", NodeID: "node-1", TaskStates: running},
+		{ID: "b2222222-alloc", JobID: "app-2", NodeID: "node-2", TaskStates: running},
+	}
+	fake.Results["app-1"] = appexec.FakeExecResult{ExitCode: 0, Stdout: "line 1\nline 2\n", Stderr: "warning\n"}
+	fake.Results["app-2"] = appexec.FakeExecResult{ExitCode: 3, Stdout: "partial\n"}
+	fake.Results["app-3"] = appexec.FakeExecResult{Err: errors.New("exec failed")}
+
+	dir := filepath.Join(t.TempDir(), "output")
+	output, err := NewOutputDir(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	appExec := appexec.NewAppExecWithExecutor(fake, 2, appexec.Options{AllocSelector: appexec.AllocSelector{Mode: appexec.AllocSelectAll}})
+	appExec.RetryPolicy.MaxAttempts = 1
+	runner := NewRunner(appExec, func() string { return "echo hello" })
+	runner.Output = output
+	runner.Run(context.Background(), Jobs([]string{"app-1", "app-2", "app-3"}))
+
+	readFile := func(path string) string {
+		data, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Errorf("Expected %s to be written: %v", path, err)
+		}
+		return string(data)
+	}
+	readMeta := func(path string) OutputMeta {
+		var meta OutputMeta
+		if err := json.Unmarshal([]byte(readFile(path)), &meta); err != nil {
+			t.Errorf("Expected %s to be JSON: %v", path, err)
+		}
+		return meta
+	}
+
+	if stdout := readFile("app-1/stdout"); stdout != "line 1\nline 2\n" {
+		t.Errorf("Expected the full stdout of app-1, got %q", stdout)
+	}
+	if stderr := readFile("app-1/stderr"); stderr != "warning\n" {
+		t.Errorf("Expected the stderr of app-1, got %q", stderr)
+	}
+	if meta := readMeta("app-1/meta.json"); meta.JobID != "app-1" || meta.ExitCode == nil || *meta.ExitCode != 0 || meta.Failed() {
+		t.Errorf("Expected a successful meta for app-1, got %+v", meta)
+	}
+
+	// A job on several allocations gets a directory per allocation
+	for _, alloc := range []string{"a2222222", "b2222222"} {
+		if stdout := readFile("app-2/" + alloc + "/stdout"); stdout != "partial\n" {
+			t.Errorf("Expected the stdout of app-2 on %s, got %q", alloc, stdout)
+		}
+		if meta := readMeta("app-2/" + alloc + "/meta.json"); meta.ErrorClass != appexec.ErrorClassExitCode {
+			t.Errorf("Expected an exit code failure for app-2 on %s, got %+v", alloc, meta)
+		}
+	}
+
+	// An exec that never returned only has its meta
+	if _, err := os.Stat(filepath.Join(dir, "app-3", "stdout")); !os.IsNotExist(err) {
+		t.Errorf("Expected no stdout for the failed exec of app-3, got %v", err)
+	}
+	if meta := readMeta("app-3/meta.json"); meta.Error == "" || meta.ExitCode != nil {
+		t.Errorf("Expected the error of app-3 in its meta, got %+v", meta)
+	}
+
+	path, err := output.Archive()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if path != dir+".tar.gz" {
+		t.Errorf("Expected the archive next to the output dir, got %s", path)
+	}
+	entries := readArchive(t, path)
+	expected := []string{
+		"output/", "output/app-1/", "output/app-1/meta.json", "output/app-1/stderr", "output/app-1/stdout",
+		"output/app-2/", "output/app-2/a2222222/", "output/app-2/a2222222/meta.json", "output/app-2/a2222222/stderr", "output/app-2/a2222222/stdout",
+		"output/app-2/b2222222/", "output/app-2/b2222222/meta.json", "output/app-2/b2222222/stderr", "output/app-2/b2222222/stdout",
+		"output/app-3/", "output/app-3/meta.json",
+	}
+	if strings.Join(entries, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected archive entries %v, got %v", expected, entries)
+	}
+}
+
+// readArchive lists the entries of a tar.gz archive
+func readArchive(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Expected a gzip archive: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	var entries []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error reading archive: %v", err)
+		}
+		entries = append(entries, header.Name)
+	}
+	sort.Strings(entries)
+	return entries
+}
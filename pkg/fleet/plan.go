@@ -0,0 +1,102 @@
+package fleet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Plan is what a run would exec on, resolved without executing anything
+type Plan struct {
+	Jobs       int      // jobs given to the run
+	Targets    []Target // allocations that would be exec'd on
+	Unresolved []Job    // jobs not resolved before the context was done
+}
+
+// NodeExecs returns the number of planned execs on each node, keyed by <cluster>/<nodeID>
+func (p *Plan) NodeExecs() map[string]int {
+	nodes := map[string]int{}
+	for _, t := range p.Targets {
+		nodes[t.nodeKey()]++
+	}
+	return nodes
+}
+
+// Log writes the plan's counts, and the busiest nodes
+func (p *Plan) Log() {
+	nodes := p.NodeExecs()
+	slog.Info("Run plan", "numJobs", p.Jobs, "numExecs", len(p.Targets), "numNodes", len(nodes), "numUnresolved", len(p.Unresolved))
+
+	keys := make([]string, 0, len(nodes))
+	for node := range nodes {
+		keys = append(keys, node)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if nodes[keys[i]] != nodes[keys[j]] {
+			return nodes[keys[i]] > nodes[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, node := range keys {
+		slog.Info("Planned execs on node", "node", node, "numExecs", nodes[node])
+	}
+}
+
+// Plan resolves the jobs to the allocations a run would exec on, without executing anything
+// Jobs that can't be resolved get an error row in the report like in Run
+func (r *Runner) Plan(ctx context.Context, jobs []Job) *Plan {
+	slog.Info("Resolving allocations of jobs", "numJobs", len(jobs), "numClusters", len(r.Clusters))
+	targets, unresolved := r.Resolve(ctx, jobs, &RunSummary{})
+	// Nothing is executed, so the job spans end with their resolution
+	for _, t := range targets {
+		t.span.done()
+	}
+	return &Plan{Jobs: len(jobs), Targets: targets, Unresolved: unresolved}
+}
+
+// WriteScripts writes the command each target would run to <dir>/[<cluster>/]<jobID>-<allocID>.sh
+// and returns the paths written
+func (r *Runner) WriteScripts(ctx context.Context, targets []Target, dir string) ([]string, error) {
+	var paths []string
+	for _, t := range targets {
+		stdin, err := r.stdin(ctx, t)
+		if err != nil {
+			return paths, fmt.Errorf("error generating command for job %s: %w", t.Job, err)
+		}
+
+		path := filepath.Join(dir, t.Job.Cluster, fmt.Sprintf("%s-%s.sh", t.Job.ID, shortID(t.Alloc.ID)))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return paths, fmt.Errorf("error creating script dir: %w", err)
+		}
+		if err := writeScript(path, stdin); err != nil {
+			return paths, fmt.Errorf("error writing script for job %s: %w", t.Job, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// writeScript streams a generated script to a file
+func writeScript(path string, script io.Reader) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, script); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// shortID shortens a Nomad UUID to its first 8 characters like the Nomad CLI does
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
@@ -0,0 +1,81 @@
+package fleet
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/inventory"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+func TestRunnerPlan(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	fake.AddAppJobOnNode("app-1", "node-a", nil)
+	fake.AddAppJobOnNode("app-2", "node-a", nil)
+	fake.AddAppJobOnNode("app-3", "node-b", nil)
+
+	appExec := appexec.NewAppExecWithExecutor(fake, 2, appexec.Options{})
+	runner := NewRunner(appExec, func() string { return "echo hello" })
+	plan := runner.Plan(context.Background(), Jobs([]string{"app-1", "app-2", "app-3", "app-missing"}))
+
+	if plan.Jobs != 4 || len(plan.Targets) != 3 || len(plan.Unresolved) != 0 {
+		t.Errorf("Expected 4 jobs and 3 targets, got %d and %d", plan.Jobs, len(plan.Targets))
+	}
+	nodes := plan.NodeExecs()
+	if len(nodes) != 2 || nodes["/node-a"] != 2 || nodes["/node-b"] != 1 {
+		t.Errorf("Expected 2 execs on node-a and 1 on node-b, got %v", nodes)
+	}
+	if len(fake.ExecCalls()) != 0 {
+		t.Errorf("Expected no exec calls, got %+v", fake.ExecCalls())
+	}
+	if failed := runner.Report.FailedJobIDs(); len(failed) != 1 || failed[0] != "app-missing" {
+		t.Errorf("Expected app-missing to fail resolution, got %v", failed)
+	}
+
+	dir := t.TempDir()
+	paths, err := runner.WriteScripts(context.Background(), plan.Targets[:1], dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := filepath.Join(dir, plan.Targets[0].Job.ID+"-"+shortID(plan.Targets[0].Alloc.ID)+".sh")
+	if len(paths) != 1 || paths[0] != expected {
+		t.Fatalf("Expected script %s, got %v", expected, paths)
+	}
+	if data, err := os.ReadFile(paths[0]); err != nil || string(data) != "echo hello" {
+		t.Errorf("Expected the script to hold the command, got %q and error %v", data, err)
+	}
+}
+
+func TestRunnerPlanInventory(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	fake.AddAppJobOnNode("app-1", "node-a", nil)
+	fake.AddAppJobOnNode("app-2", "node-b", nil)
+	snap := inventory.NewSnapshot(appexec.SitesNamespace)
+	if _, err := inventory.New(fake, appexec.SitesNamespace).Refresh(context.Background(), snap); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// node-b drains after the snapshot, moving app-2 to node-c, the snapshot only sees the drain start
+	snap.Nodes["node-b"].Drain = true
+	fake.Allocs["app-2"] = []*api.AllocationListStub{{
+		ID:           "app-2-moved",
+		JobID:        "app-2",
+		NodeID:       "node-c",
+		ClientStatus: api.AllocClientStatusRunning,
+		TaskStates:   map[string]*api.TaskState{appexec.AppUnitTaskName: {State: "running"}},
+	}}
+	allocCalls := fake.MethodCalls["JobAllocations"]
+
+	appExec := appexec.NewAppExecWithExecutor(fake, 2, appexec.Options{})
+	runner := NewClusterRunner([]Cluster{{Name: "test-1", AppExec: appExec, Inventory: snap}}, func() string { return "echo hello" })
+	plan := runner.Plan(context.Background(), Jobs([]string{"app-1", "app-2"}))
+
+	if len(plan.Targets) != 2 || plan.Targets[0].Alloc.ID != "app-1-alloc" || plan.Targets[1].Alloc.ID != "app-2-moved" {
+		t.Fatalf("Expected app-1 from the snapshot and app-2 from the cluster, got %+v", plan.Targets)
+	}
+	if calls := fake.MethodCalls["JobAllocations"] - allocCalls; calls != 1 {
+		t.Errorf("Expected only app-2 looked up on the cluster, got %d lookups", calls)
+	}
+}
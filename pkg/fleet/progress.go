@@ -0,0 +1,242 @@
+package fleet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Progress tracks the jobs of a run as they are queued, run and finish, for periodic progress reports
+// A nil Progress tracks nothing, so the runner can call it unconditionally
+type Progress struct {
+	mu        sync.Mutex
+	start     time.Time
+	total     int
+	running   int
+	succeeded int
+	failed    int
+	cancelled int
+	bytes     int64
+}
+
+// ProgressSnapshot is the state of a run at one point in time
+type ProgressSnapshot struct {
+	Total       int           `json:"total"`
+	Queued      int           `json:"queued"`
+	Running     int           `json:"running"`
+	Succeeded   int           `json:"succeeded"`
+	Failed      int           `json:"failed"`
+	Cancelled   int           `json:"cancelled"`
+	Bytes       int64         `json:"bytes"` // stdin sent and output received by the execs
+	Elapsed     time.Duration `json:"elapsed"`
+	JobsPerMin  float64       `json:"jobs_per_min"`
+	BytesPerMin float64       `json:"bytes_per_min"`
+	ETA         time.Duration `json:"eta"` // 0 until a job has finished
+}
+
+func NewProgress() *Progress {
+	return &Progress{start: time.Now()}
+}
+
+// setTotal sets the number of jobs in the run, all of them queued
+func (p *Progress) setTotal(total int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = total
+}
+
+// resolved sets the total once the jobs are resolved, to the jobs already done plus the pending ones
+func (p *Progress) resolved(pending int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = p.succeeded + p.failed + p.cancelled + pending
+}
+
+// jobStarted moves a job from queued to running
+func (p *Progress) jobStarted() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running++
+}
+
+// jobDone moves a job to its outcome, from running if it was started
+func (p *Progress) jobDone(started bool, outcome string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if started {
+		p.running--
+	}
+	switch outcome {
+	case CheckpointSucceeded:
+		p.succeeded++
+	case CheckpointFailed:
+		p.failed++
+	case CheckpointCancelled:
+		p.cancelled++
+	}
+}
+
+// addBytes adds to the bytes sent and received by the execs
+func (p *Progress) addBytes(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes += n
+}
+
+// Snapshot returns the current state of the run with its throughput and ETA
+func (p *Progress) Snapshot() ProgressSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	done := p.succeeded + p.failed + p.cancelled
+	s := ProgressSnapshot{
+		Total:     p.total,
+		Queued:    max(p.total-done-p.running, 0),
+		Running:   p.running,
+		Succeeded: p.succeeded,
+		Failed:    p.failed,
+		Cancelled: p.cancelled,
+		Bytes:     p.bytes,
+		Elapsed:   time.Since(p.start),
+	}
+	if minutes := s.Elapsed.Minutes(); minutes > 0 {
+		s.JobsPerMin = float64(done) / minutes
+		s.BytesPerMin = float64(p.bytes) / minutes
+	}
+	if s.JobsPerMin > 0 {
+		s.ETA = time.Duration(float64(s.Queued+s.Running) / s.JobsPerMin * float64(time.Minute))
+	}
+	return s
+}
+
+// Log writes the snapshot as a single log line
+func (s ProgressSnapshot) Log() {
+	slog.Info("Run progress", "total", s.Total, "queued", s.Queued, "running", s.Running, "succeeded", s.Succeeded, "failed", s.Failed, "cancelled", s.Cancelled,
+		"bytes", s.Bytes, "elapsed", s.Elapsed.Round(time.Second).String(), "jobsPerMin", round(s.JobsPerMin), "bytesPerMin", round(s.BytesPerMin), "eta", s.ETA.Round(time.Second).String())
+}
+
+// StatusLine formats the snapshot as a one line status for a terminal
+func (s ProgressSnapshot) StatusLine() string {
+	eta := "-"
+	if s.ETA > 0 {
+		eta = s.ETA.Round(time.Second).String()
+	}
+	done := s.Succeeded + s.Failed + s.Cancelled
+	return fmt.Sprintf("%d/%d jobs | %d queued, %d running, %d ok, %d failed | %.1f jobs/min, %s/min | elapsed %s, eta %s",
+		done, s.Total, s.Queued, s.Running, s.Succeeded, s.Failed, s.JobsPerMin, formatBytes(int64(s.BytesPerMin)), s.Elapsed.Round(time.Second), eta)
+}
+
+// Report reports progress every interval until ctx is done, and once more then so the final state is shown
+// Progress is logged, or set as the status of line if there is one for a terminal
+func (p *Progress) Report(ctx context.Context, interval time.Duration, line *StatusLine) {
+	report := func() {
+		s := p.Snapshot()
+		if line != nil {
+			line.Set(s.StatusLine())
+			return
+		}
+		s.Log()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			report()
+		case <-ctx.Done():
+			report()
+			if line != nil {
+				line.Done()
+			}
+			return
+		}
+	}
+}
+
+// StatusLine keeps a status line at the bottom of a terminal, below everything written through it,
+// e.g. by using it as the log output
+type StatusLine struct {
+	mu     sync.Mutex
+	w      io.Writer
+	status string
+}
+
+func NewStatusLine(w io.Writer) *StatusLine {
+	return &StatusLine{w: w}
+}
+
+// clearLine returns to the start of the terminal line and clears it
+const clearLine = "\r\x1b[K"
+
+// Write clears the status line, writes p, then redraws the status line below it
+func (l *StatusLine) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.status == "" {
+		return l.w.Write(p)
+	}
+	if _, err := io.WriteString(l.w, clearLine); err != nil {
+		return 0, err
+	}
+	n, err := l.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(l.w, l.status)
+	return n, err
+}
+
+// Set replaces the status line
+func (l *StatusLine) Set(status string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status = status
+	io.WriteString(l.w, clearLine+status)
+}
+
+// Done leaves the last status on its own line, later writes go below it
+func (l *StatusLine) Done() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.status != "" {
+		io.WriteString(l.w, "\n")
+	}
+	l.status = ""
+}
+
+// round rounds a rate to one decimal for logging
+func round(f float64) float64 {
+	return float64(int64(f*10+0.5)) / 10
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. 1.5MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
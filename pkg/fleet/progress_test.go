@@ -0,0 +1,82 @@
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+func TestRunnerProgress(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	for _, job := range []string{"app-1", "app-2", "app-3"} {
+		fake.AddAppJob(job, nil)
+	}
+	fake.Results["app-2"] = appexec.FakeExecResult{Err: errors.New("exec failed")}
+	fake.ExecDelay = 20 * time.Millisecond
+
+	appExec := appexec.NewAppExecWithExecutor(fake, 1, appexec.Options{})
+	runner := NewRunner(appExec, func() string { return "echo hello" })
+	runner.Progress = NewProgress()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runner.Run(context.Background(), Jobs([]string{"app-1", "app-2", "app-3", "app-missing"}))
+	}()
+
+	// With one exec at a time, at most one job is running while the rest are queued or done
+	time.Sleep(10 * time.Millisecond)
+	s := runner.Progress.Snapshot()
+	if s.Total != 4 || s.Running != 1 || s.Queued+s.Running+s.Succeeded+s.Failed != 4 {
+		t.Errorf("Unexpected progress mid-run: %+v", s)
+	}
+	<-done
+
+	s = runner.Progress.Snapshot()
+	if s.Total != 4 || s.Queued != 0 || s.Running != 0 || s.Succeeded != 2 || s.Failed != 2 {
+		t.Errorf("Expected 2 succeeded and 2 failed jobs, got %+v", s)
+	}
+	if s.Bytes != int64(len("echo hello")*2) || s.JobsPerMin <= 0 || s.BytesPerMin <= 0 || s.ETA != 0 {
+		t.Errorf("Unexpected throughput: %+v", s)
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	p := NewProgress()
+	p.start = time.Now().Add(-2 * time.Minute)
+	p.setTotal(10)
+	for i := 0; i < 4; i++ {
+		p.jobStarted()
+		p.jobDone(true, CheckpointSucceeded)
+	}
+	p.jobStarted()
+
+	// 4 jobs in 2 minutes leaves 6 jobs for about 3 minutes
+	s := p.Snapshot()
+	if s.Queued != 5 || s.Running != 1 || s.JobsPerMin < 1.9 || s.JobsPerMin > 2 {
+		t.Errorf("Unexpected progress: %+v", s)
+	}
+	if s.ETA < 179*time.Second || s.ETA > 181*time.Second {
+		t.Errorf("Expected an ETA of about 3m, got %v", s.ETA)
+	}
+}
+
+func TestStatusLine(t *testing.T) {
+	var out bytes.Buffer
+	line := NewStatusLine(&out)
+	line.Write([]byte("before\n"))
+	line.Set("1/2 jobs")
+	line.Write([]byte("log\n"))
+	line.Set("2/2 jobs")
+	line.Done()
+	line.Write([]byte("after\n"))
+
+	expected := "before\n" + clearLine + "1/2 jobs" + clearLine + "log\n1/2 jobs" + clearLine + "2/2 jobs\nafter\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,301 @@
+package fleet
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+const (
+	ReportFormatJSON = "json"
+	ReportFormatCSV  = "csv"
+
+	ErrorClassNotStarted appexec.ErrorClass = "not_started" // the run ended before the job was started
+)
+
+// ReportRow is the outcome of one exec on one allocation, or of a job that never got as far as an exec
+type ReportRow struct {
+	Cluster     string             `json:"cluster,omitempty"` // cluster the job came from, empty in single cluster runs
+	JobID       string             `json:"job_id"`
+	AllocID     string             `json:"alloc_id,omitempty"`
+	NodeID      string             `json:"node_id,omitempty"`
+	StartTime   time.Time          `json:"start_time"`
+	EndTime     time.Time          `json:"end_time"`
+	DurationMs  int64              `json:"duration_ms"`
+	ExitCode    *int               `json:"exit_code,omitempty"` // nil if the command never returned an exit code
+	ErrorClass  appexec.ErrorClass `json:"error_class,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	StdinBytes  int64              `json:"stdin_bytes"`
+	OutputBytes int64              `json:"output_bytes"`
+}
+
+// Job is the job the row is for, tagged with its cluster
+func (r ReportRow) Job() Job {
+	return Job{Cluster: r.Cluster, ID: r.JobID}
+}
+
+// Failed reports whether the row is an error or a non-zero exit
+func (r ReportRow) Failed() bool {
+	return r.ErrorClass != appexec.ErrorClassNone
+}
+
+// ReportTotals sums up every row of a report
+type ReportTotals struct {
+	Jobs         int                        `json:"jobs"`
+	FailedJobs   int                        `json:"failed_jobs"` // jobs with at least one failed row
+	Execs        int                        `json:"execs"`       // rows that reached an allocation
+	Succeeded    int                        `json:"succeeded"`
+	Failed       int                        `json:"failed"`
+	ErrorClasses map[appexec.ErrorClass]int `json:"error_classes,omitempty"`
+	StdinBytes   int64                      `json:"stdin_bytes"`
+	OutputBytes  int64                      `json:"output_bytes"`
+	StartTime    time.Time                  `json:"start_time"`
+	EndTime      time.Time                  `json:"end_time"`
+	DurationMs   int64                      `json:"duration_ms"`
+}
+
+// Report collects rows from concurrent execs in a run
+type Report struct {
+	mu   sync.Mutex
+	rows []ReportRow
+}
+
+func NewReport() *Report {
+	return &Report{}
+}
+
+// Add adds rows to the report, safe for concurrent use
+func (r *Report) Add(rows ...ReportRow) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rows = append(r.rows, rows...)
+}
+
+// FailedJobIDs returns the sorted IDs of jobs with at least one failed row, prefixed with their cluster if they have one
+func (r *Report) FailedJobIDs() []string {
+	var jobIDs []string
+	for _, row := range r.Rows() {
+		jobID := row.Job().String()
+		if row.Failed() && (len(jobIDs) == 0 || jobIDs[len(jobIDs)-1] != jobID) {
+			jobIDs = append(jobIDs, jobID)
+		}
+	}
+	return jobIDs
+}
+
+// Rows returns the rows sorted by cluster, job ID, then alloc ID
+func (r *Report) Rows() []ReportRow {
+	r.mu.Lock()
+	rows := append([]ReportRow(nil), r.rows...)
+	r.mu.Unlock()
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Cluster != rows[j].Cluster {
+			return rows[i].Cluster < rows[j].Cluster
+		}
+		if rows[i].JobID != rows[j].JobID {
+			return rows[i].JobID < rows[j].JobID
+		}
+		return rows[i].AllocID < rows[j].AllocID
+	})
+	return rows
+}
+
+// Totals sums up the rows of the report
+func (r *Report) Totals() ReportTotals {
+	totals := ReportTotals{ErrorClasses: map[appexec.ErrorClass]int{}}
+	jobs := map[Job]bool{}
+	failedJobs := map[Job]bool{}
+	for _, row := range r.Rows() {
+		jobs[row.Job()] = true
+		if row.AllocID != "" {
+			totals.Execs++
+		}
+		if row.Failed() {
+			totals.Failed++
+			failedJobs[row.Job()] = true
+			totals.ErrorClasses[row.ErrorClass]++
+		} else {
+			totals.Succeeded++
+		}
+		totals.StdinBytes += row.StdinBytes
+		totals.OutputBytes += row.OutputBytes
+		if !row.StartTime.IsZero() && (totals.StartTime.IsZero() || row.StartTime.Before(totals.StartTime)) {
+			totals.StartTime = row.StartTime
+		}
+		if row.EndTime.After(totals.EndTime) {
+			totals.EndTime = row.EndTime
+		}
+	}
+	totals.Jobs = len(jobs)
+	totals.FailedJobs = len(failedJobs)
+	if !totals.StartTime.IsZero() {
+		totals.DurationMs = totals.EndTime.Sub(totals.StartTime).Milliseconds()
+	}
+	return totals
+}
+
+// WriteFile writes the report to a file in the given format, or inferred from the extension if format is empty
+func (r *Report) WriteFile(path, format string) error {
+	if format == "" {
+		format = ReportFormatJSON
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			format = ReportFormatCSV
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating report file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	switch format {
+	case ReportFormatJSON:
+		err = r.WriteJSON(f)
+	case ReportFormatCSV:
+		err = r.WriteCSV(f)
+	default:
+		return fmt.Errorf("unknown report format %q, expected json or csv", format)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing report file %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// WriteJSON writes the rows followed by the totals as a JSON object
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Rows   []ReportRow  `json:"rows"`
+		Totals ReportTotals `json:"totals"`
+	}{
+		Rows:   r.Rows(),
+		Totals: r.Totals(),
+	})
+}
+
+var csvHeader = []string{"job_id", "alloc_id", "node_id", "start_time", "end_time", "duration_ms", "exit_code", "error_class", "error", "stdin_bytes", "output_bytes", "cluster"}
+
+// WriteCSV writes a header, one line per row, and a final TOTAL line
+// The TOTAL line's error column holds the succeeded and failed counts
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, row := range r.Rows() {
+		exitCode := ""
+		if row.ExitCode != nil {
+			exitCode = strconv.Itoa(*row.ExitCode)
+		}
+		cw.Write([]string{
+			row.JobID,
+			row.AllocID,
+			row.NodeID,
+			formatTime(row.StartTime),
+			formatTime(row.EndTime),
+			strconv.FormatInt(row.DurationMs, 10),
+			exitCode,
+			string(row.ErrorClass),
+			row.Error,
+			strconv.FormatInt(row.StdinBytes, 10),
+			strconv.FormatInt(row.OutputBytes, 10),
+			row.Cluster,
+		})
+	}
+
+	totals := r.Totals()
+	cw.Write([]string{
+		"TOTAL",
+		fmt.Sprintf("%d execs", totals.Execs),
+		fmt.Sprintf("%d jobs, %d failed", totals.Jobs, totals.FailedJobs),
+		formatTime(totals.StartTime),
+		formatTime(totals.EndTime),
+		strconv.FormatInt(totals.DurationMs, 10),
+		"",
+		"",
+		fmt.Sprintf("succeeded=%d failed=%d", totals.Succeeded, totals.Failed),
+		strconv.FormatInt(totals.StdinBytes, 10),
+		strconv.FormatInt(totals.OutputBytes, 10),
+		"",
+	})
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// RowsFromResults creates a report row for each allocation a command was executed on
+// Exit codes not in successCodes are classified as exit_code failures
+func RowsFromResults(results []*appexec.AllocExecResult, successCodes ExitCodes) []ReportRow {
+	rows := make([]ReportRow, 0, len(results))
+	for _, result := range results {
+		row := ReportRow{
+			JobID:      result.JobID,
+			AllocID:    result.AllocID,
+			NodeID:     result.NodeID,
+			StartTime:  result.StartTime,
+			EndTime:    result.EndTime,
+			DurationMs: result.EndTime.Sub(result.StartTime).Milliseconds(),
+			ErrorClass: appexec.ClassifyError(result.Err),
+		}
+		if result.Err != nil {
+			row.Error = result.Err.Error()
+		}
+		if resp := result.Response; resp != nil {
+			exitCode := resp.ExitCode
+			row.ExitCode = &exitCode
+			row.StdinBytes = resp.StdinBytes
+			row.OutputBytes = resp.OutputBytes
+			if !successCodes.Success(exitCode) {
+				row.ErrorClass = appexec.ErrorClassExitCode
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// ErrorRow creates a report row for a job that failed before it reached an allocation
+func ErrorRow(job Job, start, end time.Time, err error) ReportRow {
+	return ReportRow{
+		Cluster:    job.Cluster,
+		JobID:      job.ID,
+		StartTime:  start,
+		EndTime:    end,
+		DurationMs: end.Sub(start).Milliseconds(),
+		ErrorClass: appexec.ClassifyError(err),
+		Error:      err.Error(),
+	}
+}
+
+// NotStartedRows creates a report row for each job that was never started
+func NotStartedRows(jobs []Job) []ReportRow {
+	rows := make([]ReportRow, 0, len(jobs))
+	for _, job := range jobs {
+		rows = append(rows, ReportRow{
+			Cluster:    job.Cluster,
+			JobID:      job.ID,
+			ErrorClass: ErrorClassNotStarted,
+		})
+	}
+	return rows
+}
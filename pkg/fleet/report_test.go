@@ -0,0 +1,128 @@
+package fleet
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+func testReport() *Report {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	report := NewReport()
+	report.Add(RowsFromResults([]*appexec.AllocExecResult{
+		{JobID: "app-2", AllocID: "alloc-2", NodeID: "node-1", StartTime: start, EndTime: start.Add(time.Second), Response: &appexec.ExecResponse{ExitCode: 0, StdinBytes: 10, OutputBytes: 5}},
+		{JobID: "app-1", AllocID: "alloc-1", NodeID: "node-2", StartTime: start, EndTime: start.Add(2 * time.Second), Response: &appexec.ExecResponse{ExitCode: 2, StdinBytes: 10, OutputBytes: 7}},
+	}, nil)...)
+	report.Add(ErrorRow(Job{ID: "app-3"}, start, start.Add(time.Second), appexec.ErrNoRunningAlloc))
+	report.Add(NotStartedRows(Jobs([]string{"app-4"}))...)
+	return report
+}
+
+func TestReportTotals(t *testing.T) {
+	totals := testReport().Totals()
+
+	if totals.Jobs != 4 || totals.FailedJobs != 3 || totals.Execs != 2 || totals.Succeeded != 1 || totals.Failed != 3 {
+		t.Errorf("Unexpected totals: %+v", totals)
+	}
+	if totals.StdinBytes != 20 || totals.OutputBytes != 12 {
+		t.Errorf("Expected 20 stdin and 12 output bytes, got %d and %d", totals.StdinBytes, totals.OutputBytes)
+	}
+	if totals.DurationMs != 2000 {
+		t.Errorf("Expected duration 2000ms, got %d", totals.DurationMs)
+	}
+	expected := map[appexec.ErrorClass]int{
+		appexec.ErrorClassExitCode: 1,
+		appexec.ErrorClassNoAlloc:  1,
+		ErrorClassNotStarted:       1,
+	}
+	for class, count := range expected {
+		if totals.ErrorClasses[class] != count {
+			t.Errorf("Expected %d rows of class %s, got %d", count, class, totals.ErrorClasses[class])
+		}
+	}
+}
+
+func TestReportRowsSorted(t *testing.T) {
+	rows := testReport().Rows()
+	for i, jobID := range []string{"app-1", "app-2", "app-3", "app-4"} {
+		if rows[i].JobID != jobID {
+			t.Errorf("Expected row %d to be %s, got %s", i, jobID, rows[i].JobID)
+		}
+	}
+}
+
+func TestReportWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().WriteCSV(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Unexpected error reading CSV: %v", err)
+	}
+
+	if len(records) != 6 {
+		t.Fatalf("Expected header, 4 rows and a total, got %d records", len(records))
+	}
+	if records[1][6] != "2" || records[1][7] != string(appexec.ErrorClassExitCode) {
+		t.Errorf("Expected app-1 to have exit code 2 and class exit_code, got %v", records[1])
+	}
+	total := records[5]
+	if total[0] != "TOTAL" || total[8] != "succeeded=1 failed=3" || total[9] != "20" || total[10] != "12" {
+		t.Errorf("Unexpected TOTAL row: %v", total)
+	}
+}
+
+func TestReportWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReport().WriteJSON(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var decoded struct {
+		Rows   []ReportRow  `json:"rows"`
+		Totals ReportTotals `json:"totals"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Unexpected error decoding JSON: %v", err)
+	}
+
+	if len(decoded.Rows) != 4 || decoded.Totals.Failed != 3 {
+		t.Errorf("Unexpected decoded report: %+v", decoded)
+	}
+	if decoded.Rows[3].ExitCode != nil {
+		t.Errorf("Expected no exit code for a job that was not started, got %d", *decoded.Rows[3].ExitCode)
+	}
+}
+
+func TestRowsFromResultsError(t *testing.T) {
+	rows := RowsFromResults([]*appexec.AllocExecResult{
+		{JobID: "app-1", AllocID: "alloc-1", Err: errors.New("permission denied")},
+	}, nil)
+	if len(rows) != 1 || rows[0].ErrorClass != appexec.ErrorClassPermanent || rows[0].Error != "permission denied" {
+		t.Errorf("Unexpected rows: %+v", rows)
+	}
+}
+
+func TestRowsFromResultsSuccessExitCodes(t *testing.T) {
+	results := []*appexec.AllocExecResult{
+		{JobID: "app-1", AllocID: "alloc-1", Response: &appexec.ExecResponse{ExitCode: 3}},
+		{JobID: "app-2", AllocID: "alloc-2", Response: &appexec.ExecResponse{ExitCode: 0}},
+	}
+
+	rows := RowsFromResults(results, ExitCodes{0: true, 3: true})
+	for _, row := range rows {
+		if row.Failed() {
+			t.Errorf("Expected %s to succeed with exit code %d", row.JobID, *row.ExitCode)
+		}
+	}
+
+	rows = RowsFromResults(results, ExitCodes{3: true})
+	if rows[0].Failed() || !rows[1].Failed() || rows[1].ErrorClass != appexec.ErrorClassExitCode {
+		t.Errorf("Expected only exit code 3 to succeed, got %+v", rows)
+	}
+}
@@ -0,0 +1,600 @@
+package fleet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/metrics"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/tracing"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Target is one selected allocation of a job to exec on
+type Target struct {
+	Job     Job
+	Cluster *Cluster
+	Alloc   *api.AllocationListStub
+	span    *jobSpan // span of the target's job, nil outside a run
+}
+
+// JobMeta returns the meta of the target's job, from its cluster's inventory snapshot if it has one
+func (t Target) JobMeta(ctx context.Context) (map[string]string, error) {
+	if t.Cluster.Inventory != nil {
+		if job, ok := t.Cluster.Inventory.Jobs[t.Job.ID]; ok {
+			return job.Meta, nil
+		}
+	}
+	return t.Cluster.AppExec.GetJobMeta(ctx, t.Job.ID)
+}
+
+// nodeKey identifies the target's Nomad node across clusters
+func (t Target) nodeKey() string {
+	return t.Job.Cluster + "/" + t.Alloc.NodeID
+}
+
+// jobSpan is the trace span of a job from its resolution until the execs of all its targets are done,
+// shared by the targets so their spans nest under it
+type jobSpan struct {
+	span      trace.Span
+	remaining atomic.Int64
+}
+
+// context returns ctx with the job's span as the parent of new spans
+func (js *jobSpan) context(ctx context.Context) context.Context {
+	if js == nil {
+		return ctx
+	}
+	return trace.ContextWithSpan(ctx, js.span)
+}
+
+// done ends the span once every target of the job is done
+func (js *jobSpan) done() {
+	if js != nil && js.remaining.Add(-1) == 0 {
+		js.span.End()
+	}
+}
+
+// Runner runs a command on many jobs across one or more clusters
+// Each job's allocations are resolved to their nodes up front so execs can be spread across nodes, with a
+// per-node limit on top of the concurrency of each cluster's AppExec
+type Runner struct {
+	Clusters []Cluster
+	Command  func() string // generates the command for each exec
+	// TargetCommand generates the command for each exec from its target instead of Command if set, e.g. to render a script
+	TargetCommand func(ctx context.Context, t Target) (string, error)
+	// Stdin generates the commands for each exec as a reader instead of Command if set, streamed to the exec as they
+	// are read so large generated scripts are never held in memory, execs are only retried if it is an io.Seeker
+	Stdin              func() io.Reader
+	ExecTimeout        time.Duration // timeout for each exec, 0 for no timeout
+	PerNodeConcurrency int           // max concurrent execs on one Nomad node, 0 for no limit
+	ResolveConcurrency int           // max concurrent allocation lookups while resolving jobs to nodes
+	SuccessExitCodes   ExitCodes     // remote exit codes that count as success, nil for only 0
+	Checkpoint         *Checkpoint   // records the outcome of each job as it completes, nil for none
+	Progress           *Progress     // tracks the jobs of the run for progress reports, nil for none
+	Output             *OutputDir    // writes the stdout, stderr and meta of each exec to a directory, nil for none
+	Report             *Report
+}
+
+// NewRunner creates a Runner on a single cluster
+func NewRunner(appExec *appexec.AppExec, command func() string) *Runner {
+	return NewClusterRunner([]Cluster{{AppExec: appExec}}, command)
+}
+
+// NewClusterRunner creates a Runner across clusters, all of their execs go into one report
+func NewClusterRunner(clusters []Cluster, command func() string) *Runner {
+	return &Runner{
+		Clusters:           clusters,
+		Command:            command,
+		ResolveConcurrency: appexec.FilterConcurrency,
+		Report:             NewReport(),
+	}
+}
+
+// RunSummary records which jobs of a run finished, failed, were cancelled while in flight, or never started
+// A job fails if it couldn't be resolved or any of its execs errored, timed out or exited with a failed code
+type RunSummary struct {
+	mu         sync.Mutex
+	Finished   []string
+	Failed     []string
+	Cancelled  []string
+	NotStarted []string
+}
+
+func (rs *RunSummary) add(list *[]string, jobIDs ...string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	*list = append(*list, jobIDs...)
+}
+
+// Log writes the summary counts, and the job IDs that didn't finish
+func (rs *RunSummary) Log() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	slog.Info("Run summary", "finished", len(rs.Finished), "failed", len(rs.Failed), "cancelled", len(rs.Cancelled), "notStarted", len(rs.NotStarted))
+	if len(rs.Failed) > 0 {
+		slog.Warn("Jobs failed", "jobIDs", rs.Failed)
+	}
+	if len(rs.Cancelled) > 0 {
+		slog.Warn("Jobs cancelled while in flight", "jobIDs", rs.Cancelled)
+	}
+	if len(rs.NotStarted) > 0 {
+		slog.Warn("Jobs never started", "jobIDs", rs.NotStarted)
+	}
+}
+
+type targetOutcome int
+
+const (
+	targetFinished targetOutcome = iota
+	targetFailed                 // finished with an error or a failed exit code
+	targetCancelled
+	targetNotStarted
+)
+
+// jobProgress tracks the targets of a job so it lands in the summary, and the checkpoint if there is one,
+// once all of them are done
+type jobProgress struct {
+	mu         sync.Mutex
+	summary    *RunSummary
+	checkpoint *Checkpoint
+	progress   *Progress
+	jobs       map[string]*jobState
+}
+
+type jobState struct {
+	remaining int
+	running   bool
+	started   bool
+	failed    bool
+	cancelled bool
+}
+
+func newJobProgress(summary *RunSummary, checkpoint *Checkpoint, progress *Progress, targets []Target) *jobProgress {
+	jp := &jobProgress{summary: summary, checkpoint: checkpoint, progress: progress, jobs: map[string]*jobState{}}
+	for _, t := range targets {
+		jobID := t.Job.String()
+		if jp.jobs[jobID] == nil {
+			jp.jobs[jobID] = &jobState{}
+		}
+		jp.jobs[jobID].remaining++
+	}
+	return jp
+}
+
+// start records that an exec of a job is starting, the job is running from its first exec until its last is done
+func (jp *jobProgress) start(job Job) {
+	jp.mu.Lock()
+	defer jp.mu.Unlock()
+	state := jp.jobs[job.String()]
+	if !state.running {
+		state.running = true
+		jp.progress.jobStarted()
+	}
+}
+
+// done records the outcome of one target, a job that was only partly started counts as cancelled
+func (jp *jobProgress) done(job Job, outcome targetOutcome) {
+	jp.mu.Lock()
+	defer jp.mu.Unlock()
+	jobID := job.String()
+	state := jp.jobs[jobID]
+	state.remaining--
+	if outcome != targetNotStarted {
+		state.started = true
+	}
+	if outcome == targetFailed {
+		state.failed = true
+	}
+	if outcome == targetCancelled || outcome == targetNotStarted {
+		state.cancelled = true
+	}
+	if state.remaining > 0 {
+		return
+	}
+	switch {
+	case !state.started:
+		jp.summary.add(&jp.summary.NotStarted, jobID)
+		jp.progress.jobDone(state.running, "")
+	case state.cancelled:
+		jp.summary.add(&jp.summary.Cancelled, jobID)
+		jp.record(jobID, CheckpointCancelled)
+	case state.failed:
+		jp.summary.add(&jp.summary.Failed, jobID)
+		jp.record(jobID, CheckpointFailed)
+	default:
+		jp.summary.add(&jp.summary.Finished, jobID)
+		jp.record(jobID, CheckpointSucceeded)
+	}
+}
+
+// record appends a job's outcome to the checkpoint and counts it in the progress
+// A failed checkpoint write is logged and doesn't stop the run
+func (jp *jobProgress) record(jobID, outcome string) {
+	jp.progress.jobDone(jp.jobs[jobID].running, outcome)
+	if jp.checkpoint == nil {
+		return
+	}
+	if err := jp.checkpoint.Record(jobID, outcome); err != nil {
+		slog.Warn("Error recording job in checkpoint", "jobID", jobID, "outcome", outcome, "error", err)
+	}
+}
+
+// Run resolves the jobs to their allocations and runs the command on each of them
+// Every exec, resolution failure and job that never started is added to the report
+// The run is traced in a span, with a span per job from its resolution to its last exec
+func (r *Runner) Run(ctx context.Context, jobs []Job) *RunSummary {
+	ctx, span := tracing.Start(ctx, "fleet.Run", attribute.Int("jobs", len(jobs)), attribute.Int("clusters", len(r.Clusters)))
+	defer span.End()
+
+	slog.Info("Resolving allocations of jobs", "numJobs", len(jobs), "numClusters", len(r.Clusters))
+	summary := &RunSummary{}
+	r.Progress.setTotal(len(jobs))
+	targets, unresolved := r.Resolve(ctx, jobs, summary)
+	if len(unresolved) > 0 {
+		for _, job := range unresolved {
+			summary.add(&summary.NotStarted, job.String())
+		}
+		r.Report.Add(NotStartedRows(unresolved)...)
+		slog.Warn("Run cancelled while resolving allocations", "numJobs", len(unresolved), "error", context.Cause(ctx))
+	}
+
+	slog.Info("Running execs on jobs", "numJobs", len(jobs), "numExecs", len(targets), "numNodes", countNodes(targets), "perNodeConcurrency", r.PerNodeConcurrency)
+	progress := newJobProgress(summary, r.Checkpoint, r.Progress, targets)
+	// A job without a cluster can resolve to a job in each of several clusters, each counted on its own
+	r.Progress.resolved(len(unresolved) + len(progress.jobs))
+	r.Output.setTargets(targets)
+	r.dispatch(ctx, targets, progress)
+	return summary
+}
+
+// Resolve finds the selected allocations of each job, with up to ResolveConcurrency lookups in parallel
+// A job without a cluster is looked up in every cluster and runs on each cluster it has allocations in
+// Jobs that can't be resolved are finished with an error row, jobs not looked up before ctx is done are returned as unresolved
+func (r *Runner) Resolve(ctx context.Context, jobs []Job, summary *RunSummary) ([]Target, []Job) {
+	type resolved struct {
+		targets []Target
+		skipped bool
+	}
+	results := make([]resolved, len(jobs))
+
+	workers := r.ResolveConcurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if ctx.Err() != nil {
+					results[i].skipped = true
+					continue
+				}
+				start := time.Now()
+				jobCtx, span := tracing.Start(ctx, "fleet.Job", attribute.String("job.id", jobs[i].ID), attribute.String("cluster", jobs[i].Cluster))
+				targets, err := r.resolveJob(jobCtx, jobs[i])
+				if err != nil {
+					tracing.End(span, err)
+				}
+				switch {
+				case err != nil && ctx.Err() != nil:
+					results[i].skipped = true
+				case err != nil:
+					slog.Warn("Error resolving allocations of job", "jobID", jobs[i].ID, "cluster", jobs[i].Cluster, "error", err)
+					r.Report.Add(ErrorRow(jobs[i], start, time.Now(), err))
+					summary.add(&summary.Failed, jobs[i].String())
+					r.Progress.jobDone(false, CheckpointFailed)
+					if r.Checkpoint != nil {
+						if err := r.Checkpoint.Record(jobs[i].String(), CheckpointFailed); err != nil {
+							slog.Warn("Error recording job in checkpoint", "jobID", jobs[i].ID, "cluster", jobs[i].Cluster, "error", err)
+						}
+					}
+				default:
+					js := &jobSpan{span: span}
+					js.remaining.Store(int64(len(targets)))
+					for j := range targets {
+						targets[j].span = js
+					}
+					results[i].targets = targets
+				}
+			}
+		}()
+	}
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var targets []Target
+	var unresolved []Job
+	for i, result := range results {
+		if result.skipped {
+			unresolved = append(unresolved, jobs[i])
+			continue
+		}
+		targets = append(targets, result.targets...)
+	}
+	return targets, unresolved
+}
+
+// resolveJob finds the selected allocations of a job in its cluster, or in every cluster if it has none
+// A job looked up in every cluster only fails if it has no allocations in any of them
+func (r *Runner) resolveJob(ctx context.Context, job Job) ([]Target, error) {
+	var targets []Target
+	var firstErr error
+	for i := range r.Clusters {
+		cluster := &r.Clusters[i]
+		if job.Cluster != "" && job.Cluster != cluster.Name {
+			continue
+		}
+		allocs, err := cluster.appUnitAllocs(ctx, job.ID)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, alloc := range allocs {
+			targets = append(targets, Target{Job: Job{Cluster: cluster.Name, ID: job.ID}, Cluster: cluster, Alloc: alloc})
+		}
+	}
+	if len(targets) > 0 {
+		return targets, nil
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("unknown cluster %s", job.Cluster)
+	}
+	return nil, firstErr
+}
+
+// allocClaims tracks the allocations a run execs into, so a stopped allocation is only ever replaced by one no
+// other target of the run has, e.g. with -allocSelect all
+type allocClaims struct {
+	mu      sync.Mutex
+	claimed map[string]bool // keyed by cluster and alloc ID
+}
+
+func newAllocClaims(targets []Target) *allocClaims {
+	c := &allocClaims{claimed: map[string]bool{}}
+	for _, t := range targets {
+		c.claimed[t.Job.Cluster+"/"+t.Alloc.ID] = true
+	}
+	return c
+}
+
+// claim takes an allocation of a cluster for a target, false if a target already has it
+func (c *allocClaims) claim(cluster, allocID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cluster + "/" + allocID
+	if c.claimed[key] {
+		return false
+	}
+	c.claimed[key] = true
+	return true
+}
+
+func countNodes(targets []Target) int {
+	nodes := map[string]bool{}
+	for _, t := range targets {
+		nodes[t.nodeKey()] = true
+	}
+	return len(nodes)
+}
+
+// dispatch starts an exec per target, taking nodes in turn so consecutive execs land on different nodes
+// A node is skipped while it has PerNodeConcurrency execs in flight or its cluster's AppExec has no free exec slot
+func (r *Runner) dispatch(ctx context.Context, targets []Target, progress *jobProgress) {
+	var nodes []string
+	queues := map[string][]Target{}
+	for _, t := range targets {
+		node := t.nodeKey()
+		if _, ok := queues[node]; !ok {
+			nodes = append(nodes, node)
+		}
+		queues[node] = append(queues[node], t)
+	}
+
+	claims := newAllocClaims(targets)
+
+	// Buffered so a finished exec never blocks on the dispatcher
+	finished := make(chan string, len(targets))
+	inFlight := map[string]int{}
+	cursor := 0
+
+	// next returns the next node in turn with queued targets and room for another exec, holding an exec slot of its cluster
+	next := func() (string, bool) {
+		for i := range nodes {
+			node := nodes[(cursor+i)%len(nodes)]
+			if len(queues[node]) == 0 || (r.PerNodeConcurrency > 0 && inFlight[node] >= r.PerNodeConcurrency) {
+				continue
+			}
+			if !queues[node][0].Cluster.AppExec.TryAppExec() {
+				continue
+			}
+			cursor = (cursor + i + 1) % len(nodes)
+			return node, true
+		}
+		return "", false
+	}
+
+	wg := sync.WaitGroup{}
+	started := 0
+dispatchLoop:
+	for started < len(targets) {
+		// Free up the nodes of execs that have finished
+	drain:
+		for {
+			select {
+			case node := <-finished:
+				inFlight[node]--
+			default:
+				break drain
+			}
+		}
+
+		// Stop scheduling new execs once the run is cancelled
+		if ctx.Err() != nil {
+			break
+		}
+		node, ok := next()
+		if !ok {
+			// Every node with queued targets or its cluster is at its limit, wait for an exec to finish
+			select {
+			case node := <-finished:
+				inFlight[node]--
+				continue
+			case <-ctx.Done():
+				break dispatchLoop
+			}
+		}
+
+		target := queues[node][0]
+		queues[node] = queues[node][1:]
+		inFlight[node]++
+		started++
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { finished <- node }()
+			defer target.Cluster.AppExec.ReleaseAppExec()
+			progress.start(target.Job)
+			progress.done(target.Job, r.execTarget(ctx, target, claims))
+			target.span.done()
+		}()
+	}
+
+	if started < len(targets) {
+		slog.Warn("Run cancelled, not starting remaining execs", "numExecs", len(targets)-started, "error", context.Cause(ctx))
+		for _, node := range nodes {
+			for _, t := range queues[node] {
+				r.Report.Add(NotStartedRows([]Job{t.Job})...)
+				progress.done(t.Job, targetNotStarted)
+				t.span.done()
+			}
+		}
+	}
+	wg.Wait()
+}
+
+// stdin generates the commands for a target as the reader the exec streams from
+func (r *Runner) stdin(ctx context.Context, target Target) (io.Reader, error) {
+	switch {
+	case r.TargetCommand != nil:
+		command, err := r.TargetCommand(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(command), nil
+	case r.Stdin != nil:
+		return r.Stdin(), nil
+	default:
+		return strings.NewReader(r.Command()), nil
+	}
+}
+
+// writeOutput writes a target's output to the output dir if there is one
+// A failed write is logged and doesn't fail the exec
+func (r *Runner) writeOutput(target Target, row ReportRow, resp *appexec.ExecResponse) {
+	if err := r.Output.write(target, row, resp); err != nil {
+		slog.Warn("Error writing output of job", "jobID", target.Job.ID, "cluster", target.Job.Cluster, "allocID", target.Alloc.ID, "error", err)
+	}
+}
+
+// execTarget runs the command on one target and adds its row to the report, traced in a span under the job's
+// The target's allocation is checked again first, as it was resolved when the run started and may have stopped since,
+// and a stopped one is only replaced by an allocation no other target of the run has
+func (r *Runner) execTarget(ctx context.Context, target Target, claims *allocClaims) targetOutcome {
+	ctx, span := tracing.Start(target.span.context(ctx), "fleet.Exec", attribute.String("job.id", target.Job.ID), attribute.String("cluster", target.Job.Cluster),
+		attribute.String("alloc.id", target.Alloc.ID), attribute.String("node.id", target.Alloc.NodeID))
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	execCtx := ctx
+	if r.ExecTimeout > 0 {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithTimeout(ctx, r.ExecTimeout)
+		defer cancel()
+	}
+
+	jobID, cluster := target.Job.ID, target.Job.Cluster
+	start := time.Now()
+	var alloc *api.AllocationListStub
+	alloc, err = target.Cluster.AppExec.RefreshAppUnitAlloc(execCtx, jobID, target.Alloc, func(alloc *api.AllocationListStub) bool {
+		return claims.claim(cluster, alloc.ID)
+	})
+	if err != nil {
+		row := ErrorRow(target.Job, start, time.Now(), err)
+		row.AllocID, row.NodeID = target.Alloc.ID, target.Alloc.NodeID
+		r.Report.Add(row)
+		r.writeOutput(target, row, nil)
+		if ctx.Err() != nil {
+			slog.Warn("Cancelled exec to job", "jobID", jobID, "cluster", cluster, "allocID", target.Alloc.ID, "nodeID", target.Alloc.NodeID)
+			return targetCancelled
+		}
+		slog.Warn("Error checking allocation of job", "jobID", jobID, "cluster", cluster, "allocID", target.Alloc.ID, "error", err)
+		return targetFailed
+	}
+	if alloc.ID != target.Alloc.ID {
+		slog.Info("Replaced stopped allocation of job", "jobID", jobID, "cluster", cluster, "oldAllocID", target.Alloc.ID, "allocID", alloc.ID, "nodeID", alloc.NodeID)
+		span.SetAttributes(attribute.String("alloc.id", alloc.ID), attribute.String("node.id", alloc.NodeID))
+	}
+	target.Alloc = alloc
+
+	var stdin io.Reader
+	stdin, err = r.stdin(execCtx, target)
+	if err != nil {
+		slog.Warn("Error generating command for job", "jobID", jobID, "cluster", cluster, "allocID", target.Alloc.ID, "error", err)
+		row := ErrorRow(target.Job, time.Now(), time.Now(), err)
+		row.AllocID, row.NodeID = target.Alloc.ID, target.Alloc.NodeID
+		r.Report.Add(row)
+		r.writeOutput(target, row, nil)
+		return targetFailed
+	}
+
+	slog.Info("Starting exec to job", "jobID", jobID, "cluster", cluster, "allocID", target.Alloc.ID, "nodeID", target.Alloc.NodeID)
+	result := target.Cluster.AppExec.ExecuteStdinOnAlloc(execCtx, jobID, target.Alloc, stdin)
+	rows := RowsFromResults([]*appexec.AllocExecResult{result}, r.SuccessExitCodes)
+	for i := range rows {
+		rows[i].Cluster = cluster
+		r.Progress.addBytes(rows[i].StdinBytes + rows[i].OutputBytes)
+		metrics.ObserveExec(string(rows[i].ErrorClass), time.Duration(rows[i].DurationMs)*time.Millisecond)
+	}
+	r.Report.Add(rows...)
+	r.writeOutput(target, rows[0], result.Response)
+	err = result.Err
+	if result.Response != nil {
+		span.SetAttributes(attribute.Int("exit_code", result.Response.ExitCode))
+	}
+
+	outcome := targetFinished
+	switch {
+	case result.Err != nil && ctx.Err() != nil:
+		slog.Warn("Cancelled exec to job", "jobID", jobID, "cluster", cluster, "allocID", result.AllocID, "nodeID", result.NodeID)
+		return targetCancelled
+	case result.Err != nil:
+		slog.Warn("Error executing command on job", "jobID", jobID, "cluster", cluster, "allocID", result.AllocID, "nodeID", result.NodeID, "error", result.Err)
+		outcome = targetFailed
+	case !r.SuccessExitCodes.Success(result.Response.ExitCode):
+		slog.Warn("Command failed on job", "jobID", jobID, "cluster", cluster, "allocID", result.AllocID, "nodeID", result.NodeID, "exitCode", result.Response.ExitCode, "outputTruncated", result.Response.Truncated)
+		outcome = targetFailed
+	default:
+		// Output has already been streamed to the log line by line during the exec
+		slog.Debug("Command executed successfully on job", "jobID", jobID, "cluster", cluster, "allocID", result.AllocID, "nodeID", result.NodeID, "exitCode", result.Response.ExitCode, "outputTruncated", result.Response.Truncated)
+	}
+	slog.Info("Finished exec to job", "jobID", jobID, "cluster", cluster, "allocID", result.AllocID)
+	return outcome
+}
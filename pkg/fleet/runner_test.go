@@ -0,0 +1,413 @@
+package fleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestRunnerRun(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	jobs := []string{"app-1", "app-2", "app-3"}
+	for _, job := range jobs {
+		fake.AddAppJob(job, nil)
+	}
+	fake.Results["app-2"] = appexec.FakeExecResult{Err: errors.New("exec failed")}
+
+	appExec := appexec.NewAppExecWithExecutor(fake, 2, appexec.Options{})
+	runner := NewRunner(appExec, func() string { return "echo hello" })
+	summary := runner.Run(context.Background(), Jobs(jobs))
+
+	calls := fake.ExecCalls()
+	if len(calls) != len(jobs) {
+		t.Fatalf("Expected %d exec calls, got %d", len(jobs), len(calls))
+	}
+	var execJobs []string
+	for _, call := range calls {
+		execJobs = append(execJobs, call.JobID)
+		if call.Stdin != "echo hello" {
+			t.Errorf("Expected stdin 'echo hello' for job %s, got %q", call.JobID, call.Stdin)
+		}
+	}
+	sort.Strings(execJobs)
+	for i, job := range jobs {
+		if execJobs[i] != job {
+			t.Errorf("Expected exec on job %s, got %s", job, execJobs[i])
+		}
+	}
+	if len(summary.Finished) != 2 || len(summary.Failed) != 1 || summary.Failed[0] != "app-2" || len(summary.Cancelled) != 0 || len(summary.NotStarted) != 0 {
+		t.Errorf("Expected app-2 failed and the other jobs finished, got %+v", summary)
+	}
+
+	totals := runner.Report.Totals()
+	if totals.Jobs != 3 || totals.Execs != 3 || totals.Succeeded != 2 || totals.Failed != 1 {
+		t.Errorf("Unexpected report totals: %+v", totals)
+	}
+	if totals.StdinBytes != int64(len("echo hello")*2) {
+		t.Errorf("Expected stdin bytes from the two successful execs, got %d", totals.StdinBytes)
+	}
+}
+
+func TestRunnerCancelled(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	jobs := []string{"app-1", "app-2", "app-3", "app-4"}
+	for _, job := range jobs {
+		fake.AddAppJob(job, nil)
+	}
+	fake.ExecDelay = time.Minute
+
+	// With a concurrency of 2 the first two jobs are in flight when the run times out
+	appExec := appexec.NewAppExecWithExecutor(fake, 2, appexec.Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	runner := NewRunner(appExec, func() string { return "sleep 60" })
+	summary := runner.Run(ctx, Jobs(jobs))
+
+	if len(summary.Finished) != 0 {
+		t.Errorf("Expected no finished jobs, got %v", summary.Finished)
+	}
+	if len(summary.Cancelled) != 2 {
+		t.Errorf("Expected 2 cancelled jobs, got %v", summary.Cancelled)
+	}
+	sort.Strings(summary.NotStarted)
+	if len(summary.NotStarted) != 2 || summary.NotStarted[0] != "app-3" || summary.NotStarted[1] != "app-4" {
+		t.Errorf("Expected app-3 and app-4 not started, got %v", summary.NotStarted)
+	}
+	classes := runner.Report.Totals().ErrorClasses
+	if classes[appexec.ErrorClassTimeout] != 2 || classes[ErrorClassNotStarted] != 2 {
+		t.Errorf("Expected 2 timed out and 2 not started rows, got %v", classes)
+	}
+}
+
+func TestRunnerExecTimeout(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	fake.ExecDelay = time.Minute
+	appExec := appexec.NewAppExecWithExecutor(fake, 1, appexec.Options{})
+
+	runner := NewRunner(appExec, func() string { return "sleep 60" })
+	runner.ExecTimeout = 20 * time.Millisecond
+	summary := runner.Run(context.Background(), Jobs([]string{"app-1"}))
+
+	rows := runner.Report.Rows()
+	if len(rows) != 1 || rows[0].ErrorClass != appexec.ErrorClassTimeout {
+		t.Errorf("Expected a single timed out row, got %+v", rows)
+	}
+	// A timed out exec is a failure of the job, not a cancelled run
+	if len(summary.Failed) != 1 || len(summary.Finished) != 0 || len(summary.Cancelled) != 0 {
+		t.Errorf("Expected app-1 failed, got %+v", summary)
+	}
+}
+
+func TestRunnerMissingAlloc(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	appExec := appexec.NewAppExecWithExecutor(fake, 1, appexec.Options{})
+
+	runner := NewRunner(appExec, func() string { return "echo hello" })
+	summary := runner.Run(context.Background(), Jobs([]string{"app-missing", "app-1"}))
+
+	rows := runner.Report.Rows()
+	if len(rows) != 2 || rows[1].JobID != "app-missing" || rows[1].ErrorClass != appexec.ErrorClassNoAlloc {
+		t.Errorf("Expected a no_running_alloc row for app-missing, got %+v", rows)
+	}
+	if len(summary.Finished) != 1 || len(summary.Failed) != 1 || summary.Failed[0] != "app-missing" {
+		t.Errorf("Expected app-1 finished and app-missing failed, got %+v", summary)
+	}
+	calls := fake.ExecCalls()
+	if len(calls) != 1 || calls[0].JobID != "app-1" {
+		t.Errorf("Expected a single exec on app-1, got %+v", calls)
+	}
+}
+
+func TestRunnerStaleAlloc(t *testing.T) {
+	tests := []struct {
+		name          string
+		replaced      bool
+		expectedAlloc string
+	}{
+		{name: "replaced allocation is exec'd into instead", replaced: true, expectedAlloc: "app-1-new"},
+		{name: "stopped allocation with no replacement fails the job"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := appexec.NewFakeExecutor()
+			fake.AddAppJob("app-1", nil)
+			appExec := appexec.NewAppExecWithExecutor(fake, 1, appexec.Options{})
+			runner := NewRunner(appExec, func() string { return "echo hello" })
+
+			summary := &RunSummary{}
+			targets, _ := runner.Resolve(context.Background(), Jobs([]string{"app-1"}), summary)
+
+			// The allocation stops between resolving the job and its exec starting
+			fake.Allocs["app-1"][0].TaskStates[appexec.AppUnitTaskName].State = "dead"
+			if tt.replaced {
+				fake.Allocs["app-1"] = append(fake.Allocs["app-1"], &api.AllocationListStub{
+					ID:           "app-1-new",
+					JobID:        "app-1",
+					ClientStatus: api.AllocClientStatusRunning,
+					TaskStates:   map[string]*api.TaskState{appexec.AppUnitTaskName: {State: "running"}},
+					CreateIndex:  fake.Index + 1,
+				})
+			}
+			runner.dispatch(context.Background(), targets, newJobProgress(summary, nil, nil, targets))
+
+			calls := fake.ExecCalls()
+			rows := runner.Report.Rows()
+			if tt.replaced {
+				if len(calls) != 1 || calls[0].AllocID != tt.expectedAlloc {
+					t.Errorf("Expected a single exec on %s, got %+v", tt.expectedAlloc, calls)
+				}
+				if len(summary.Finished) != 1 {
+					t.Errorf("Expected app-1 finished, got %+v", summary)
+				}
+				return
+			}
+			if len(calls) != 0 {
+				t.Errorf("Expected no exec on the stopped allocation, got %+v", calls)
+			}
+			if len(rows) != 1 || rows[0].ErrorClass != appexec.ErrorClassNoAlloc {
+				t.Errorf("Expected a no_running_alloc row, got %+v", rows)
+			}
+			if len(summary.Failed) != 1 {
+				t.Errorf("Expected app-1 failed, got %+v", summary)
+			}
+		})
+	}
+}
+
+func TestRunnerStoppedAllocSelectAll(t *testing.T) {
+	tests := []struct {
+		name          string
+		replacement   bool
+		expectedExecs []string
+	}{
+		{name: "allocation already targeted isn't exec'd into twice", expectedExecs: []string{"a-2"}},
+		{name: "free replacement is exec'd into", replacement: true, expectedExecs: []string{"a-2", "a-3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := appexec.NewFakeExecutor()
+			running := func(id string, index uint64) *api.AllocationListStub {
+				return &api.AllocationListStub{ID: id, JobID: "app-1", ClientStatus: api.AllocClientStatusRunning, CreateIndex: index,
+					TaskStates: map[string]*api.TaskState{appexec.AppUnitTaskName: {State: "running"}}}
+			}
+			fake.AddAppJob("app-1", nil)
+			fake.Allocs["app-1"] = []*api.AllocationListStub{running("a-1", 1), running("a-2", 2)}
+			opts := appexec.Options{TaskName: appexec.AppUnitTaskName, AllocSelector: appexec.AllocSelector{Mode: appexec.AllocSelectAll}}
+			appExec := appexec.NewAppExecWithExecutor(fake, 1, opts)
+			runner := NewRunner(appExec, func() string { return "echo hello" })
+
+			summary := &RunSummary{}
+			targets, _ := runner.Resolve(context.Background(), Jobs([]string{"app-1"}), summary)
+			if len(targets) != 2 {
+				t.Fatalf("Expected 2 targets, got %d", len(targets))
+			}
+
+			// a-1 stops before its exec starts, while a-2 is still a target of the run
+			fake.Allocs["app-1"][0].TaskStates[appexec.AppUnitTaskName].State = "dead"
+			if tt.replacement {
+				fake.Allocs["app-1"] = append(fake.Allocs["app-1"], running("a-3", 3))
+			}
+			runner.dispatch(context.Background(), targets, newJobProgress(summary, nil, nil, targets))
+
+			var execs []string
+			for _, call := range fake.ExecCalls() {
+				execs = append(execs, call.AllocID)
+			}
+			sort.Strings(execs)
+			if strings.Join(execs, ",") != strings.Join(tt.expectedExecs, ",") {
+				t.Errorf("Expected execs on %v, got %v", tt.expectedExecs, execs)
+			}
+			if tt.replacement {
+				return
+			}
+			var stopped []ReportRow
+			for _, row := range runner.Report.Rows() {
+				if row.ErrorClass == appexec.ErrorClassStopped {
+					stopped = append(stopped, row)
+				}
+			}
+			if len(stopped) != 1 || stopped[0].AllocID != "a-1" {
+				t.Errorf("Expected a-1 reported as stopped, got %+v", runner.Report.Rows())
+			}
+			if len(summary.Failed) != 1 {
+				t.Errorf("Expected app-1 failed, got %+v", summary)
+			}
+		})
+	}
+}
+
+func TestRunnerSuccessExitCodes(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	jobs := []string{"app-1", "app-2", "app-3"}
+	for _, job := range jobs {
+		fake.AddAppJob(job, nil)
+	}
+	fake.Results["app-2"] = appexec.FakeExecResult{ExitCode: 1, Stderr: "No space left on device"}
+	fake.Results["app-3"] = appexec.FakeExecResult{ExitCode: 3}
+
+	appExec := appexec.NewAppExecWithExecutor(fake, 2, appexec.Options{})
+	runner := NewRunner(appExec, func() string { return "echo hello" })
+	runner.SuccessExitCodes = ExitCodes{0: true, 3: true}
+	runner.Run(context.Background(), Jobs(jobs))
+
+	failed := runner.Report.FailedJobIDs()
+	if len(failed) != 1 || failed[0] != "app-2" {
+		t.Errorf("Expected only app-2 to fail, got %v", failed)
+	}
+}
+
+func TestRunnerPerNodeConcurrency(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	// Dense node-a has six jobs, node-b and node-c have two each
+	var jobs []string
+	for i := 0; i < 6; i++ {
+		jobs = append(jobs, fmt.Sprintf("a-%d", i))
+		fake.AddAppJobOnNode(jobs[len(jobs)-1], "node-a", nil)
+	}
+	for _, node := range []string{"b", "c"} {
+		for i := 0; i < 2; i++ {
+			jobs = append(jobs, fmt.Sprintf("%s-%d", node, i))
+			fake.AddAppJobOnNode(jobs[len(jobs)-1], "node-"+node, nil)
+		}
+	}
+	fake.ExecDelay = 20 * time.Millisecond
+
+	appExec := appexec.NewAppExecWithExecutor(fake, 5, appexec.Options{})
+	runner := NewRunner(appExec, func() string { return "echo hello" })
+	runner.PerNodeConcurrency = 2
+	summary := runner.Run(context.Background(), Jobs(jobs))
+
+	if len(summary.Finished) != len(jobs) {
+		t.Errorf("Expected all jobs finished, got %+v", summary)
+	}
+	for node, max := range fake.MaxInFlight {
+		if max > 2 {
+			t.Errorf("Expected at most 2 concurrent execs on %s, got %d", node, max)
+		}
+	}
+	if fake.MaxInFlight["node-a"] != 2 {
+		t.Errorf("Expected node-a to reach its limit of 2, got %d", fake.MaxInFlight["node-a"])
+	}
+}
+
+func TestRunnerInterleavesNodes(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	jobs := []string{"a-0", "a-1", "a-2", "b-0", "b-1", "c-0"}
+	for _, job := range jobs {
+		fake.AddAppJobOnNode(job, "node-"+job[:1], nil)
+	}
+
+	// With a single exec at a time the order of execs is the order of dispatch
+	appExec := appexec.NewAppExecWithExecutor(fake, 1, appexec.Options{})
+	runner := NewRunner(appExec, func() string { return "echo hello" })
+	runner.Run(context.Background(), Jobs(jobs))
+
+	expected := []string{"a-0", "b-0", "c-0", "a-1", "b-1", "a-2"}
+	calls := fake.ExecCalls()
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected %d exec calls, got %d", len(expected), len(calls))
+	}
+	for i, call := range calls {
+		if call.JobID != expected[i] {
+			t.Errorf("Expected exec %d on %s, got %s", i, expected[i], call.JobID)
+		}
+	}
+}
+
+func TestRunnerTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	fake := appexec.NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	fake.AddAppJob("app-2", nil)
+	fake.Results["app-2"] = appexec.FakeExecResult{Err: errors.New("exec failed")}
+	appExec := appexec.NewAppExecWithExecutor(fake, 2, appexec.Options{})
+
+	runner := NewRunner(appExec, func() string { return "echo hello" })
+	runner.Run(context.Background(), Jobs([]string{"app-1", "app-2", "app-missing"}))
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	var run sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "fleet.Run":
+			run = span
+		case "fleet.Job", "fleet.Exec":
+			for _, attr := range span.Attributes() {
+				if attr.Key == "job.id" {
+					spans[span.Name()+" "+attr.Value.AsString()] = span
+				}
+			}
+		}
+	}
+	if run == nil {
+		t.Fatal("Expected a fleet.Run span")
+	}
+	for _, jobID := range []string{"app-1", "app-2", "app-missing"} {
+		job := spans["fleet.Job "+jobID]
+		if job == nil || job.Parent().SpanID() != run.SpanContext().SpanID() {
+			t.Errorf("Expected a fleet.Job span of %s under the run span", jobID)
+			continue
+		}
+		exec := spans["fleet.Exec "+jobID]
+		if jobID == "app-missing" {
+			if exec != nil || job.Status().Code != codes.Error {
+				t.Errorf("Expected the unresolved %s to have no exec span and a failed job span", jobID)
+			}
+			continue
+		}
+		if exec == nil || exec.Parent().SpanID() != job.SpanContext().SpanID() {
+			t.Errorf("Expected a fleet.Exec span of %s under its job span", jobID)
+		}
+	}
+	if exec := spans["fleet.Exec app-2"]; exec != nil && exec.Status().Code != codes.Error {
+		t.Errorf("Expected the failed exec of app-2 to have an error status, got %v", exec.Status())
+	}
+}
+
+func TestRunnerStdin(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	fake.AddAppJob("app-2", nil)
+	appExec := appexec.NewAppExecWithExecutor(fake, 2, appexec.Options{})
+
+	// Each exec gets its own reader, streamed to its stdin as it is read
+	runner := NewRunner(appExec, nil)
+	runner.Stdin = func() io.Reader {
+		return io.MultiReader(strings.NewReader("echo "), strings.NewReader("streamed\n"))
+	}
+	runner.Run(context.Background(), Jobs([]string{"app-1", "app-2"}))
+
+	calls := fake.ExecCalls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 exec calls, got %d", len(calls))
+	}
+	for _, call := range calls {
+		if call.Stdin != "echo streamed\n" {
+			t.Errorf("Expected the streamed stdin for job %s, got %q", call.JobID, call.Stdin)
+		}
+	}
+	if totals := runner.Report.Totals(); totals.StdinBytes != int64(2*len("echo streamed\n")) {
+		t.Errorf("Expected the streamed stdin bytes in the report, got %d", totals.StdinBytes)
+	}
+}
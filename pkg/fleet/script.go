@@ -0,0 +1,22 @@
+package fleet
+
+import (
+	"context"
+
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/scripts"
+)
+
+// ScriptCommand renders a library script for each target, for Runner.TargetCommand
+// vars should already have the script's defaults applied with Script.Vars
+func ScriptCommand(script *scripts.Script, vars map[string]string) func(ctx context.Context, t Target) (string, error) {
+	return func(ctx context.Context, t Target) (string, error) {
+		return script.Render(&scripts.Context{
+			JobID:    t.Job.ID,
+			AllocID:  t.Alloc.ID,
+			NodeID:   t.Alloc.NodeID,
+			Cluster:  t.Job.Cluster,
+			Vars:     vars,
+			MetaFunc: func() (map[string]string, error) { return t.JobMeta(ctx) },
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package fleet
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/scripts"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+func TestScriptCommand(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	fake.AddAppJob("app-1", map[string]string{"tier": "gold"})
+	fake.AddAppJob("app-2", nil)
+
+	script, err := scripts.Parse("test", `# var: path=./wp-content Directory
+du -sh {{quote .Vars.path}} # {{.JobID}} {{.Meta "tier"}}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	vars, err := script.Vars(map[string]string{"path": "./uploads"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	appExec := appexec.NewAppExecWithExecutor(fake, 1, appexec.Options{})
+	runner := NewRunner(appExec, nil)
+	runner.TargetCommand = ScriptCommand(script, vars)
+	runner.Run(context.Background(), Jobs([]string{"app-1", "app-2"}))
+
+	expected := map[string]string{
+		"app-1": "du -sh './uploads' # app-1 gold",
+		"app-2": "du -sh './uploads' # app-2 ",
+	}
+	calls := fake.ExecCalls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 exec calls, got %d", len(calls))
+	}
+	for _, call := range calls {
+		if !strings.HasSuffix(call.Stdin, "\n"+expected[call.JobID]) {
+			t.Errorf("Expected stdin ending %q for job %s, got %q", expected[call.JobID], call.JobID, call.Stdin)
+		}
+	}
+}
+
+func TestScriptCommandError(t *testing.T) {
+	fake := appexec.NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+
+	script, err := scripts.Parse("test", `echo {{.Vars.missing}}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	appExec := appexec.NewAppExecWithExecutor(fake, 1, appexec.Options{})
+	runner := NewRunner(appExec, nil)
+	runner.TargetCommand = ScriptCommand(script, map[string]string{})
+	summary := runner.Run(context.Background(), Jobs([]string{"app-1"}))
+
+	// A script that fails to render is a failure of the job and nothing is executed
+	if len(fake.ExecCalls()) != 0 {
+		t.Errorf("Expected no exec calls, got %+v", fake.ExecCalls())
+	}
+	rows := runner.Report.Rows()
+	if len(rows) != 1 || !rows[0].Failed() || rows[0].AllocID == "" {
+		t.Errorf("Expected a failed row for the allocation, got %+v", rows)
+	}
+	if len(summary.Failed) != 1 {
+		t.Errorf("Expected app-1 failed, got %+v", summary)
+	}
+}
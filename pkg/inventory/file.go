@@ -0,0 +1,104 @@
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// FileVersion is the version of the inventory file format, files of other versions are rebuilt
+const FileVersion = 1
+
+// File is an inventory file holding the snapshots of one or more cluster namespaces
+type File struct {
+	Path      string
+	mu        sync.Mutex
+	snapshots map[string]*Snapshot
+}
+
+type fileContents struct {
+	Version   int                  `json:"version"`
+	Snapshots map[string]*Snapshot `json:"snapshots"`
+}
+
+// Key identifies the snapshot of a namespace of the cluster and region that config points at
+func Key(config *api.Config, namespace string) string {
+	key := config.Address
+	if config.Region != "" {
+		key += "?region=" + config.Region
+	}
+	return key + "#" + namespace
+}
+
+// Load reads an inventory file, a missing file or one of another version loads with no snapshots
+func Load(path string) (*File, error) {
+	f := &File{Path: path, snapshots: map[string]*Snapshot{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading inventory file %s: %w", path, err)
+	}
+
+	var contents fileContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, fmt.Errorf("error parsing inventory file %s: %w", path, err)
+	}
+	if contents.Version == FileVersion && contents.Snapshots != nil {
+		f.snapshots = contents.Snapshots
+	}
+	return f, nil
+}
+
+// Get returns the snapshot stored under key, or nil if there is none
+func (f *File) Get(key string) *Snapshot {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.snapshots[key]
+}
+
+// Put stores a snapshot under key, Save writes it to the file
+func (f *File) Put(key string, snap *Snapshot) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.snapshots[key] = snap
+}
+
+// Save writes the snapshots to the file, replacing it in one rename so readers never see a partial file
+func (f *File) Save() error {
+	f.mu.Lock()
+	data, err := json.Marshal(fileContents{Version: FileVersion, Snapshots: f.snapshots})
+	f.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding inventory: %w", err)
+	}
+
+	if dir := filepath.Dir(f.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("error creating inventory dir: %w", err)
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing inventory file %s: %w", f.Path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing inventory file %s: %w", f.Path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing inventory file %s: %w", f.Path, err)
+	}
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		return fmt.Errorf("error writing inventory file %s: %w", f.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+const (
+	DefaultTTL      = 15 * time.Minute // how old a snapshot can be before it is refreshed
+	DefaultWaitTime = time.Second      // how long a refresh waits for a change before treating a list as unchanged
+	InfoConcurrency = 10               // max concurrent job info lookups of changed jobs
+)
+
+// Inventory builds and refreshes snapshots of the jobs, allocations and nodes of a cluster namespace
+type Inventory struct {
+	Executor        appexec.Executor
+	Namespace       string
+	RetryPolicy     appexec.RetryPolicy // retry policy applied to every Nomad call
+	InfoConcurrency int                 // max concurrent job info lookups of changed jobs
+	WaitTime        time.Duration       // how long each blocking query waits for a change past the snapshot's index
+}
+
+// New creates an Inventory of a namespace backed by any Executor
+func New(executor appexec.Executor, namespace string) *Inventory {
+	return &Inventory{
+		Executor:        executor,
+		Namespace:       namespace,
+		RetryPolicy:     appexec.DefaultRetryPolicy(),
+		InfoConcurrency: InfoConcurrency,
+		WaitTime:        DefaultWaitTime,
+	}
+}
+
+// Sync returns the snapshot of the namespace stored in f under key, building it if there is none and
+// refreshing it if it is older than ttl. A fresh snapshot is returned as is, without calling the cluster
+func (inv *Inventory) Sync(ctx context.Context, f *File, key string, ttl time.Duration) (*Snapshot, error) {
+	snap := f.Get(key)
+	if snap != nil && snap.Namespace == inv.Namespace && !snap.Stale(ttl) {
+		slog.Info("Using inventory snapshot", "key", key, "numJobs", len(snap.Jobs), "age", time.Since(snap.RefreshedAt).Round(time.Second))
+		return snap, nil
+	}
+	if snap == nil || snap.Namespace != inv.Namespace {
+		snap = NewSnapshot(inv.Namespace)
+	}
+	if _, err := inv.Refresh(ctx, snap); err != nil {
+		return nil, err
+	}
+	f.Put(key, snap)
+	return snap, nil
+}
+
+// Refresh brings a snapshot up to date and reports whether anything changed
+// Jobs, allocations and nodes are each listed with a blocking query from the snapshot's index for them, which
+// returns as soon as there is a change or after WaitTime if there is none. Only new jobs and jobs whose
+// JobModifyIndex moved are looked up. The snapshot is left as it was if any call fails
+func (inv *Inventory) Refresh(ctx context.Context, snap *Snapshot) (bool, error) {
+	start := time.Now()
+	var jobs map[string]*Job
+	var allocs map[string]*Alloc
+	var nodes map[string]*Node
+	var jobsIndex, allocsIndex, nodesIndex uint64
+	errs := make([]error, 3)
+
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		jobs, jobsIndex, errs[0] = inv.refreshJobs(ctx, snap)
+	}()
+	go func() {
+		defer wg.Done()
+		allocs, allocsIndex, errs[1] = inv.refreshAllocs(ctx, snap)
+	}()
+	go func() {
+		defer wg.Done()
+		nodes, nodesIndex, errs[2] = inv.refreshNodes(ctx, snap)
+	}()
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return false, fmt.Errorf("error refreshing inventory of namespace %s: %w", inv.Namespace, err)
+		}
+	}
+
+	// A nil map means the list was unchanged since the snapshot's index
+	changed := false
+	if jobs != nil {
+		snap.Jobs, snap.JobsIndex, changed = jobs, jobsIndex, true
+	}
+	if allocs != nil {
+		snap.Allocs, snap.AllocsIndex, changed = allocs, allocsIndex, true
+	}
+	if nodes != nil {
+		snap.Nodes, snap.NodesIndex, changed = nodes, nodesIndex, true
+	}
+	snap.RefreshedAt = time.Now()
+	if snap.CreatedAt.IsZero() {
+		snap.CreatedAt = snap.RefreshedAt
+	}
+	slog.Info("Refreshed inventory snapshot", "namespace", inv.Namespace, "changed", changed, "numJobs", len(snap.Jobs), "numAllocs", len(snap.Allocs), "numNodes", len(snap.Nodes), "duration", time.Since(start))
+	return changed, nil
+}
+
+// queryOptions returns blocking query options from index, a zero index doesn't block
+func (inv *Inventory) queryOptions(namespace string, index uint64) *api.QueryOptions {
+	return &api.QueryOptions{
+		Namespace:  namespace,
+		AllowStale: true,
+		WaitIndex:  index,
+		WaitTime:   inv.WaitTime,
+	}
+}
+
+// unchanged reports whether a list at lastIndex has nothing new for a snapshot at index
+func unchanged(index, lastIndex uint64) bool {
+	return index != 0 && lastIndex <= index
+}
+
+// refreshJobs lists the jobs and looks up the ones that changed, or returns nil if none did
+func (inv *Inventory) refreshJobs(ctx context.Context, snap *Snapshot) (map[string]*Job, uint64, error) {
+	var stubs []*api.JobListStub
+	var meta *api.QueryMeta
+	err := inv.RetryPolicy.Do(ctx, "ListJobs", "", func() error {
+		var err error
+		stubs, meta, err = inv.Executor.ListJobs(ctx, nil, inv.queryOptions(inv.Namespace, snap.JobsIndex))
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if unchanged(snap.JobsIndex, meta.LastIndex) {
+		return nil, 0, nil
+	}
+
+	jobs := make(map[string]*Job, len(stubs))
+	var changedIDs []string
+	for _, stub := range stubs {
+		old, ok := snap.Jobs[stub.ID]
+		if !ok || old.ModifyIndex == 0 || old.ModifyIndex != stub.JobModifyIndex {
+			changedIDs = append(changedIDs, stub.ID)
+			continue
+		}
+		// Status changes with allocations and doesn't move the JobModifyIndex, so take it from the stub
+		job := *old
+		job.Status = stub.Status
+		jobs[stub.ID] = &job
+	}
+
+	changed, err := inv.jobInfos(ctx, changedIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, job := range changed {
+		jobs[job.ID] = job
+	}
+	return jobs, meta.LastIndex, nil
+}
+
+// jobInfos looks up jobs with a bounded worker pool, failing if any lookup does
+func (inv *Inventory) jobInfos(ctx context.Context, jobIDs []string) ([]*Job, error) {
+	jobs := make([]*Job, len(jobIDs))
+	errs := make([]error, len(jobIDs))
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for range max(inv.InfoConcurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = inv.RetryPolicy.Do(ctx, "JobInfo", jobIDs[i], func() error {
+					job, _, err := inv.Executor.JobInfo(ctx, jobIDs[i], &api.QueryOptions{Namespace: inv.Namespace, AllowStale: true})
+					if err == nil {
+						jobs[i] = newJob(job)
+					}
+					return err
+				})
+			}
+		}()
+	}
+	for i := range jobIDs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("error getting job info for job %s: %w", jobIDs[i], err)
+		}
+	}
+	return jobs, nil
+}
+
+// refreshAllocs lists the pending and running allocations, or returns nil if none changed
+func (inv *Inventory) refreshAllocs(ctx context.Context, snap *Snapshot) (map[string]*Alloc, uint64, error) {
+	var stubs []*api.AllocationListStub
+	var meta *api.QueryMeta
+	err := inv.RetryPolicy.Do(ctx, "ListAllocations", "", func() error {
+		var err error
+		stubs, meta, err = inv.Executor.ListAllocations(ctx, inv.queryOptions(inv.Namespace, snap.AllocsIndex))
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if unchanged(snap.AllocsIndex, meta.LastIndex) {
+		return nil, 0, nil
+	}
+
+	allocs := make(map[string]*Alloc, len(stubs))
+	for _, stub := range stubs {
+		if stub.ClientStatus == api.AllocClientStatusPending || stub.ClientStatus == api.AllocClientStatusRunning {
+			allocs[stub.ID] = newAlloc(stub)
+		}
+	}
+	return allocs, meta.LastIndex, nil
+}
+
+// refreshNodes lists the client nodes, or returns nil if none changed
+func (inv *Inventory) refreshNodes(ctx context.Context, snap *Snapshot) (map[string]*Node, uint64, error) {
+	var stubs []*api.NodeListStub
+	var meta *api.QueryMeta
+	err := inv.RetryPolicy.Do(ctx, "ListNodes", "", func() error {
+		var err error
+		// Nodes aren't namespaced
+		stubs, meta, err = inv.Executor.ListNodes(ctx, inv.queryOptions("", snap.NodesIndex))
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if unchanged(snap.NodesIndex, meta.LastIndex) {
+		return nil, 0, nil
+	}
+
+	nodes := make(map[string]*Node, len(stubs))
+	for _, stub := range stubs {
+		nodes[stub.ID] = newNode(stub)
+	}
+	return nodes, meta.LastIndex, nil
+}
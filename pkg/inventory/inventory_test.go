@@ -0,0 +1,166 @@
+package inventory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/selector"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+)
+
+func newTestInventory() (*Inventory, *appexec.FakeExecutor) {
+	fake := appexec.NewFakeExecutor()
+	fake.AddAppJobOnNode("app-1", "node-1", map[string]string{"tier": "gold"})
+	fake.AddAppJobOnNode("app-2", "node-2", map[string]string{"tier": "silver"})
+	fake.AddAppJobOnNode("app-3", "node-1", nil)
+	return New(fake, appexec.SitesNamespace), fake
+}
+
+func TestRefresh(t *testing.T) {
+	inv, fake := newTestInventory()
+	snap := NewSnapshot(appexec.SitesNamespace)
+
+	changed, err := inv.Refresh(context.Background(), snap)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !changed || len(snap.Jobs) != 3 || len(snap.Allocs) != 3 || len(snap.Nodes) != 2 {
+		t.Fatalf("Expected 3 jobs, 3 allocs and 2 nodes, got %d, %d and %d", len(snap.Jobs), len(snap.Allocs), len(snap.Nodes))
+	}
+	if fake.MethodCalls["JobInfo"] != 3 {
+		t.Errorf("Expected 3 job info calls to build the snapshot, got %d", fake.MethodCalls["JobInfo"])
+	}
+	if allocs := snap.JobAllocs("app-1"); len(allocs) != 1 || allocs[0].NodeID != "node-1" || allocs[0].TaskStates[appexec.AppUnitTaskName] != "running" {
+		t.Errorf("Unexpected allocs for app-1: %+v", allocs)
+	}
+
+	// Nothing changed, so nothing is looked up
+	changed, err = inv.Refresh(context.Background(), snap)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if changed || fake.MethodCalls["JobInfo"] != 3 {
+		t.Errorf("Expected an unchanged refresh with no job info calls, got changed %v and %d calls", changed, fake.MethodCalls["JobInfo"])
+	}
+
+	// Only the updated job is looked up, and removed jobs are dropped
+	pool := "dense"
+	fake.UpdateJob("app-2", func(job *api.Job) { job.NodePool = &pool })
+	fake.RemoveJob("app-3")
+	changed, err = inv.Refresh(context.Background(), snap)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !changed || fake.MethodCalls["JobInfo"] != 4 {
+		t.Errorf("Expected a changed refresh with one more job info call, got changed %v and %d calls", changed, fake.MethodCalls["JobInfo"])
+	}
+	if len(snap.Jobs) != 2 || snap.Jobs["app-2"].NodePool != "dense" || len(snap.JobAllocs("app-3")) != 0 {
+		t.Errorf("Expected app-2 in the dense pool and app-3 gone, got %+v", snap.Jobs)
+	}
+}
+
+func TestRefreshError(t *testing.T) {
+	inv, fake := newTestInventory()
+	inv.RetryPolicy.MaxAttempts = 1
+	fake.JobInfoErrs["app-2"] = os.ErrPermission
+	snap := NewSnapshot(appexec.SitesNamespace)
+
+	if _, err := inv.Refresh(context.Background(), snap); err == nil || !strings.Contains(err.Error(), "app-2") {
+		t.Fatalf("Expected an error for app-2, got %v", err)
+	}
+	if len(snap.Jobs) != 0 || snap.JobsIndex != 0 || !snap.RefreshedAt.IsZero() {
+		t.Errorf("Expected the snapshot to be left as it was, got %+v", snap)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	inv, _ := newTestInventory()
+	snap := NewSnapshot(appexec.SitesNamespace)
+	if _, err := inv.Refresh(context.Background(), snap); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		selector string
+		expected string
+	}{
+		{selector: "", expected: "app-1,app-2,app-3"},
+		{selector: "meta.tier in [gold, silver]", expected: "app-1,app-2"},
+		{selector: "not meta.tier exists", expected: "app-3"},
+		{selector: "node_pool == dense", expected: ""},
+	}
+
+	for _, tt := range tests {
+		var sel *selector.Selector
+		if tt.selector != "" {
+			sel = selector.MustParse(tt.selector)
+		}
+		if jobIDs := strings.Join(snap.Select(sel), ","); jobIDs != tt.expected {
+			t.Errorf("Expected %q to select %s, got %s", tt.selector, tt.expected, jobIDs)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	inv, fake := newTestInventory()
+	path := filepath.Join(t.TempDir(), "inventory", "inventory.json")
+	key := Key(&api.Config{Address: "https://127.0.0.1:4646", Region: "us-west"}, appexec.SitesNamespace)
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading a missing file: %v", err)
+	}
+	if _, err := inv.Sync(context.Background(), f, key, time.Hour); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := f.Save(); err != nil {
+		t.Fatalf("Unexpected error saving: %v", err)
+	}
+	listCalls := fake.MethodCalls["ListJobs"]
+
+	// A fresh snapshot is used from the file without calling the cluster
+	f, err = Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	snap, err := inv.Sync(context.Background(), f, key, time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(snap.Jobs) != 3 || fake.MethodCalls["ListJobs"] != listCalls {
+		t.Errorf("Expected 3 jobs from the file with no list calls, got %d jobs and %d calls", len(snap.Jobs), fake.MethodCalls["ListJobs"]-listCalls)
+	}
+	if snap.Jobs["app-1"].Meta["tier"] != "gold" {
+		t.Errorf("Expected app-1 meta to round trip, got %v", snap.Jobs["app-1"].Meta)
+	}
+
+	// A stale snapshot is refreshed from its indexes, so unchanged jobs aren't looked up again
+	fake.AddAppJob("app-4", nil)
+	jobInfoCalls := fake.MethodCalls["JobInfo"]
+	snap, err = inv.Sync(context.Background(), f, key, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(snap.Jobs) != 4 || fake.MethodCalls["JobInfo"] != jobInfoCalls+1 {
+		t.Errorf("Expected 4 jobs after one job info call, got %d jobs and %d calls", len(snap.Jobs), fake.MethodCalls["JobInfo"]-jobInfoCalls)
+	}
+}
+
+func TestLoadOtherVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	if err := os.WriteFile(path, []byte(`{"version": 0, "snapshots": {"key": {"namespace": "sites"}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Get("key") != nil {
+		t.Error("Expected snapshots of another file version to be dropped")
+	}
+}
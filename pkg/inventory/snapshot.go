@@ -0,0 +1,190 @@
+// Package inventory snapshots the jobs, allocations and nodes of a Nomad cluster namespace to a local file,
+// so runs can select jobs without listing and looking up every job on the cluster each time
+// A snapshot is refreshed incrementally with blocking queries from the Raft indexes it was last refreshed at,
+// only looking up the jobs that changed since
+package inventory
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/selector"
+)
+
+// Job is a job in a snapshot
+type Job struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Status      string            `json:"status"`
+	NodePool    string            `json:"node_pool,omitempty"`
+	Datacenters []string          `json:"datacenters,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	ModifyIndex uint64            `json:"modify_index"` // JobModifyIndex of the job version the snapshot has
+}
+
+// newJob creates a Job from a full job
+func newJob(job *api.Job) *Job {
+	j := selector.FromJob(job)
+	inv := &Job{
+		ID:          j.ID,
+		Name:        j.Name,
+		Type:        j.Type,
+		Status:      j.Status,
+		NodePool:    j.NodePool,
+		Datacenters: j.Datacenters,
+		Meta:        j.Meta,
+	}
+	if job.JobModifyIndex != nil {
+		inv.ModifyIndex = *job.JobModifyIndex
+	}
+	return inv
+}
+
+// SelectorJob is the view of the job that selectors are matched against
+func (j *Job) SelectorJob() *selector.Job {
+	return &selector.Job{
+		ID:          j.ID,
+		Name:        j.Name,
+		Type:        j.Type,
+		Status:      j.Status,
+		NodePool:    j.NodePool,
+		Datacenters: j.Datacenters,
+		Meta:        j.Meta,
+	}
+}
+
+// Alloc is a pending or running allocation in a snapshot
+type Alloc struct {
+	ID           string            `json:"id"`
+	JobID        string            `json:"job_id"`
+	NodeID       string            `json:"node_id"`
+	ClientStatus string            `json:"client_status"`
+	TaskStates   map[string]string `json:"task_states,omitempty"` // state of each task keyed by task name
+	CreateIndex  uint64            `json:"create_index"`
+	ModifyIndex  uint64            `json:"modify_index"`
+}
+
+func newAlloc(stub *api.AllocationListStub) *Alloc {
+	alloc := &Alloc{
+		ID:           stub.ID,
+		JobID:        stub.JobID,
+		NodeID:       stub.NodeID,
+		ClientStatus: stub.ClientStatus,
+		CreateIndex:  stub.CreateIndex,
+		ModifyIndex:  stub.ModifyIndex,
+	}
+	if len(stub.TaskStates) > 0 {
+		alloc.TaskStates = make(map[string]string, len(stub.TaskStates))
+		for task, state := range stub.TaskStates {
+			alloc.TaskStates[task] = state.State
+		}
+	}
+	return alloc
+}
+
+// Stub is the allocation as the list stub AppExec selects allocations from
+func (a *Alloc) Stub() *api.AllocationListStub {
+	stub := &api.AllocationListStub{
+		ID:           a.ID,
+		JobID:        a.JobID,
+		NodeID:       a.NodeID,
+		ClientStatus: a.ClientStatus,
+		CreateIndex:  a.CreateIndex,
+		ModifyIndex:  a.ModifyIndex,
+	}
+	if len(a.TaskStates) > 0 {
+		stub.TaskStates = make(map[string]*api.TaskState, len(a.TaskStates))
+		for task, state := range a.TaskStates {
+			stub.TaskStates[task] = &api.TaskState{State: state}
+		}
+	}
+	return stub
+}
+
+// Node is a client node in a snapshot
+type Node struct {
+	ID                    string `json:"id"`
+	Name                  string `json:"name"`
+	Datacenter            string `json:"datacenter"`
+	NodePool              string `json:"node_pool,omitempty"`
+	Status                string `json:"status"`
+	SchedulingEligibility string `json:"scheduling_eligibility,omitempty"`
+	Drain                 bool   `json:"drain,omitempty"`
+}
+
+func newNode(stub *api.NodeListStub) *Node {
+	return &Node{
+		ID:                    stub.ID,
+		Name:                  stub.Name,
+		Datacenter:            stub.Datacenter,
+		NodePool:              stub.NodePool,
+		Status:                stub.Status,
+		SchedulingEligibility: stub.SchedulingEligibility,
+		Drain:                 stub.Drain,
+	}
+}
+
+// Snapshot is the jobs, current allocations and nodes of a cluster namespace at some Raft indexes
+type Snapshot struct {
+	Namespace   string            `json:"namespace"`
+	CreatedAt   time.Time         `json:"created_at"`
+	RefreshedAt time.Time         `json:"refreshed_at"`
+	JobsIndex   uint64            `json:"jobs_index"`
+	AllocsIndex uint64            `json:"allocs_index"`
+	NodesIndex  uint64            `json:"nodes_index"`
+	Jobs        map[string]*Job   `json:"jobs"`
+	Allocs      map[string]*Alloc `json:"allocs"`
+	Nodes       map[string]*Node  `json:"nodes"`
+}
+
+// NewSnapshot creates an empty snapshot of a namespace, the first refresh fills it
+func NewSnapshot(namespace string) *Snapshot {
+	return &Snapshot{
+		Namespace: namespace,
+		Jobs:      map[string]*Job{},
+		Allocs:    map[string]*Alloc{},
+		Nodes:     map[string]*Node{},
+	}
+}
+
+// Stale reports whether the snapshot was last refreshed longer than ttl ago, or never
+func (s *Snapshot) Stale(ttl time.Duration) bool {
+	return s.RefreshedAt.IsZero() || time.Since(s.RefreshedAt) > ttl
+}
+
+// Select returns the IDs of the jobs matching a selector, sorted, a nil selector matches every job
+func (s *Snapshot) Select(sel *selector.Selector) []string {
+	var jobIDs []string
+	for id, job := range s.Jobs {
+		if sel.Match(job.SelectorJob()) {
+			jobIDs = append(jobIDs, id)
+		}
+	}
+	sort.Strings(jobIDs)
+	return jobIDs
+}
+
+// JobAllocs returns the current allocations of a job, oldest first
+func (s *Snapshot) JobAllocs(jobID string) []*Alloc {
+	var allocs []*Alloc
+	for _, alloc := range s.Allocs {
+		if alloc.JobID == jobID {
+			allocs = append(allocs, alloc)
+		}
+	}
+	slices.SortFunc(allocs, func(a, b *Alloc) int {
+		return cmp.Or(cmp.Compare(a.CreateIndex, b.CreateIndex), cmp.Compare(a.ID, b.ID))
+	})
+	return allocs
+}
+
+// NodeReady reports whether the snapshot has a node as ready and not draining, so its allocations aren't about to stop
+// A node missing from the snapshot is assumed to be ready
+func (s *Snapshot) NodeReady(nodeID string) bool {
+	node, ok := s.Nodes[nodeID]
+	return !ok || (node.Status == api.NodeStatusReady && !node.Drain)
+}
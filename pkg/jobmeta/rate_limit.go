@@ -1,8 +1,11 @@
 package jobmeta
 
 import (
+	"context"
 	"time"
 
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/metrics"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/tracing"
 	"golang.org/x/time/rate"
 )
 
@@ -14,3 +17,14 @@ func NewRateLimiter(burst, limit int, interval time.Duration) *rate.Limiter {
 	}
 	return nil
 }
+
+// waitLimiter waits for permission from a limiter created by NewRateLimiter, recording the wait in the metrics
+// and as a span
+func waitLimiter(ctx context.Context, limiter *rate.Limiter) error {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "jobmeta.RateLimiterWait")
+	err := limiter.Wait(ctx)
+	tracing.End(span, err)
+	metrics.RateLimiterWait.Observe(time.Since(start).Seconds())
+	return err
+}
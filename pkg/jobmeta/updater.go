@@ -6,8 +6,15 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/metrics"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/selector"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/tracing"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/utils/appexec"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/time/rate"
 )
 
@@ -15,11 +22,18 @@ import (
 type Updater struct {
 	client  *api.Client
 	limiter *rate.Limiter
+
+	// WaitTimeout is how long UpdateJobs waits for each updated job's app-unit task to be running at
+	// the new job version, 0 to not wait
+	WaitTimeout time.Duration
 }
 
 // NewUpdater creates a new JobMetaUpdater instance
-func NewUpdater(limiter *rate.Limiter) (*Updater, error) {
-	config := api.DefaultConfig()
+// A nil config uses api.DefaultConfig, configured from the Nomad client ENV vars
+func NewUpdater(config *api.Config, limiter *rate.Limiter) (*Updater, error) {
+	if config == nil {
+		config = api.DefaultConfig()
+	}
 	client, err := api.NewClient(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Nomad client: %w", err)
@@ -32,14 +46,18 @@ func NewUpdater(limiter *rate.Limiter) (*Updater, error) {
 }
 
 // UpdateJobMeta updates the meta tags of a specific job and submits the update
-func (u *Updater) UpdateJobMeta(ctx context.Context, jobID, namespace string, metaUpdates map[string]string) {
+// It returns the job version the update registers, which allocations are at once they are updated
+func (u *Updater) UpdateJobMeta(ctx context.Context, jobID, namespace string, metaUpdates map[string]string) (uint64, error) {
 	// Get the current job
-	job, _, err := u.client.Jobs().Info(jobID, &api.QueryOptions{
+	callCtx, done := appexec.StartNomadCall(ctx, "Jobs.Info", attribute.String("job.id", jobID))
+	job, _, err := u.client.Jobs().Info(jobID, (&api.QueryOptions{
 		Namespace: namespace,
-	})
+	}).WithContext(callCtx))
+	done(&err)
 	if err != nil {
 		log.Printf("failed to get job %s: %v", jobID, err)
-		return
+		metrics.JobMetaUpdates.WithLabelValues("failed").Inc()
+		return 0, fmt.Errorf("failed to get job %s: %w", jobID, err)
 	}
 
 	// Initialize meta map if it doesn't exist
@@ -47,29 +65,59 @@ func (u *Updater) UpdateJobMeta(ctx context.Context, jobID, namespace string, me
 		job.Meta = make(map[string]string)
 	}
 
-	// Update the meta tags
+	// Update the meta tags, the job version only moves if one of them changes
+	var version uint64
+	if job.Version != nil {
+		version = *job.Version
+	}
+	changed := false
 	for key, value := range metaUpdates {
+		if current, ok := job.Meta[key]; !ok || current != value {
+			changed = true
+		}
 		job.Meta[key] = value
 	}
+	if changed {
+		version++
+	}
 
 	// Submit the job update
 	writeOpts := &api.WriteOptions{
 		Namespace: namespace,
 	}
 
-	response, _, err := u.client.Jobs().Register(job, writeOpts)
+	callCtx, done = appexec.StartNomadCall(ctx, "Jobs.Register", attribute.String("job.id", jobID))
+	response, _, err := u.client.Jobs().Register(job, writeOpts.WithContext(callCtx))
+	done(&err)
 	if err != nil {
 		log.Printf("failed to register job update: %v", err)
-		return
+		metrics.JobMetaUpdates.WithLabelValues("failed").Inc()
+		return 0, fmt.Errorf("failed to register job %s update: %w", jobID, err)
 	}
+	metrics.JobMetaUpdates.WithLabelValues("submitted").Inc()
 
 	log.Printf("Job %s update submitted successfully. Evaluation ID: %s", jobID, response.EvalID)
+	return version, nil
+}
+
+// WaitForJob waits up to WaitTimeout for the job's app-unit task to be running at version or later
+func (u *Updater) WaitForJob(ctx context.Context, jobID, namespace string, version uint64) error {
+	appExec := appexec.NewAppExec(u.client, 1, appexec.Options{Namespace: namespace})
+	allocs, err := appExec.WaitForAppUnitAllocs(ctx, jobID, version, u.WaitTimeout)
+	if err != nil {
+		log.Printf("Job %s not running at version %d: %v", jobID, version, err)
+		return err
+	}
+	log.Printf("Job %s running at version %d on allocation %s", jobID, version, allocs[0].ID)
+	return nil
 }
 
 func (u *Updater) GetJobs(ctx context.Context, namespace string, jobPattern string) ([]*api.JobListStub, error) {
-	jobs, _, err := u.client.Jobs().List(&api.QueryOptions{
+	callCtx, done := appexec.StartNomadCall(ctx, "Jobs.List")
+	jobs, _, err := u.client.Jobs().List((&api.QueryOptions{
 		Namespace: namespace,
-	})
+	}).WithContext(callCtx))
+	done(&err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list jobs: %w", err)
 	}
@@ -84,6 +132,17 @@ func (u *Updater) GetJobs(ctx context.Context, namespace string, jobPattern stri
 	return matchingJobs, nil
 }
 
+// GetSelectedJobs lists the IDs of the jobs in the namespace matching a selector
+// The selector is filtered server-side where possible, with job info lookups for what the job list doesn't have
+func (u *Updater) GetSelectedJobs(ctx context.Context, namespace string, sel *selector.Selector) ([]string, error) {
+	appExec := appexec.NewAppExec(u.client, 1, appexec.Options{Namespace: namespace})
+	jobIDs, err := appExec.GetJobs(ctx, sel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	return jobIDs, nil
+}
+
 // UpdateMultipleJobs updates meta tags for multiple jobs based on a pattern or list
 func (u *Updater) UpdateMultipleJobs(ctx context.Context, namespace string, jobPattern string, metaUpdates map[string]string) error {
 	// List jobs in the namespace
@@ -92,25 +151,61 @@ func (u *Updater) UpdateMultipleJobs(ctx context.Context, namespace string, jobP
 		return fmt.Errorf("failed to list jobs: %w", err)
 	}
 
-	log.Printf("Found %d jobs to update", len(jobs))
+	jobIDs := make([]string, len(jobs))
+	for i, jobStub := range jobs {
+		jobIDs[i] = jobStub.ID
+	}
+	return u.UpdateJobs(ctx, namespace, jobIDs, metaUpdates)
+}
+
+// UpdateSelectedJobs updates meta tags for the jobs matching a selector
+func (u *Updater) UpdateSelectedJobs(ctx context.Context, namespace string, sel *selector.Selector, metaUpdates map[string]string) error {
+	jobIDs, err := u.GetSelectedJobs(ctx, namespace, sel)
+	if err != nil {
+		return err
+	}
+	return u.UpdateJobs(ctx, namespace, jobIDs, metaUpdates)
+}
+
+// UpdateJobs updates meta tags for each job concurrently, waiting on the rate limiter if there is one
+// With WaitTimeout set, it then waits for each updated job to be running and fails if any isn't
+// Each job is traced in its own span under the span of the whole update
+func (u *Updater) UpdateJobs(ctx context.Context, namespace string, jobIDs []string, metaUpdates map[string]string) (err error) {
+	ctx, span := tracing.Start(ctx, "jobmeta.UpdateJobs", attribute.String("namespace", namespace), attribute.Int("jobs", len(jobIDs)))
+	defer func() { tracing.End(span, err) }()
+
+	log.Printf("Found %d jobs to update", len(jobIDs))
+	var notRunning atomic.Int64
 	wg := sync.WaitGroup{}
-	for _, jobStub := range jobs {
+	for _, jobID := range jobIDs {
 
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			ctx, span := tracing.Start(ctx, "jobmeta.UpdateJob", attribute.String("job.id", jobID))
+			var err error
+			defer func() { tracing.End(span, err) }()
 			if u.limiter != nil {
-				if err := u.limiter.Wait(ctx); err != nil {
+				if err = waitLimiter(ctx, u.limiter); err != nil {
 					log.Printf("failed to wait for permission: %v", err)
 					return
 				}
 			}
-			u.UpdateJobMeta(ctx, jobStub.ID, namespace, metaUpdates)
+			version, err := u.UpdateJobMeta(ctx, jobID, namespace, metaUpdates)
+			if err != nil || u.WaitTimeout <= 0 {
+				return
+			}
+			if err = u.WaitForJob(ctx, jobID, namespace, version); err != nil {
+				notRunning.Add(1)
+			}
 		}()
 	}
 
 	log.Print("Waiting for jobs to finish")
 	wg.Wait()
-	log.Printf("Finish sending requests %d jobs", len(jobs))
+	log.Printf("Finish sending requests %d jobs", len(jobIDs))
+	if n := notRunning.Load(); n > 0 {
+		return fmt.Errorf("%d of %d jobs not running within %v of their update", n, len(jobIDs), u.WaitTimeout)
+	}
 	return nil
 }
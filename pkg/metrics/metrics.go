@@ -0,0 +1,113 @@
+// Package metrics holds the Prometheus metrics of the tools and serves them for scraping
+// Metrics are always recorded, and only exposed if Serve is called
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "plat_v2_tools"
+
+// Registry holds the tools' metrics with the Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	// ExecDuration is the latency of each exec into an allocation, by its error class, "none" for success
+	ExecDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "exec_duration_seconds",
+		Help:      "Duration of execs into allocations by error class, none for success.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 15), // 100ms to ~27m
+	}, []string{"error_class"})
+
+	// Execs counts the outcome of each exec by its error class, "none" for success
+	Execs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "execs_total",
+		Help:      "Execs into allocations by error class, none for success.",
+	}, []string{"error_class"})
+
+	// NomadRequests counts Nomad API calls by method and result, "ok" or "error"
+	NomadRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nomad_requests_total",
+		Help:      "Nomad API calls by method, e.g. Jobs.List, and result, ok or error.",
+	}, []string{"method", "result"})
+
+	// NomadRequestDuration is the latency of Nomad API calls by method, blocking queries include their wait
+	NomadRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "nomad_request_duration_seconds",
+		Help:      "Duration of Nomad API calls by method, including the wait of blocking queries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	// JobMetaUpdates counts job meta updates by result, "submitted" or "failed"
+	JobMetaUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobmeta_updates_total",
+		Help:      "Job meta updates by result, submitted or failed.",
+	}, []string{"result"})
+
+	// RateLimiterWait is how long each job meta update waited on the rate limiter
+	RateLimiterWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rate_limiter_wait_seconds",
+		Help:      "Time job meta updates waited on the rate limiter.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10), // 1ms to ~4m
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ExecDuration, Execs, NomadRequests, NomadRequestDuration, JobMetaUpdates, RateLimiterWait,
+	)
+}
+
+// ObserveExec records the duration and outcome of an exec, an empty error class is a success
+func ObserveExec(errorClass string, duration time.Duration) {
+	if errorClass == "" {
+		errorClass = "none"
+	}
+	ExecDuration.WithLabelValues(errorClass).Observe(duration.Seconds())
+	Execs.WithLabelValues(errorClass).Inc()
+}
+
+// ObserveNomadCall records a Nomad API call that started at start and returned err
+func ObserveNomadCall(method string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	NomadRequests.WithLabelValues(method, result).Inc()
+	NomadRequestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// Serve serves the metrics at /metrics on addr, e.g. :9100, until the process exits
+// The address is bound before returning so a port in use fails at start-up, and is the server's Addr
+func Serve(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: listener.Addr().String(), Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Error serving metrics", "error", err)
+		}
+	}()
+	return server, nil
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	server, err := Serve("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer server.Close()
+
+	ObserveExec("", 2*time.Second)
+	ObserveExec("timeout", time.Minute)
+	ObserveNomadCall("Jobs.List", time.Now(), nil)
+	ObserveNomadCall("Jobs.Info", time.Now(), errors.New("unexpected response code: 500"))
+
+	resp, err := http.Get("http://" + server.Addr + "/metrics")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, expected := range []string{
+		`plat_v2_tools_execs_total{error_class="none"} 1`,
+		`plat_v2_tools_execs_total{error_class="timeout"} 1`,
+		`plat_v2_tools_exec_duration_seconds_count{error_class="timeout"} 1`,
+		`plat_v2_tools_nomad_requests_total{method="Jobs.List",result="ok"} 1`,
+		`plat_v2_tools_nomad_requests_total{method="Jobs.Info",result="error"} 1`,
+		`plat_v2_tools_nomad_request_duration_seconds_count{method="Jobs.Info"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected the metrics to contain %s", expected)
+		}
+	}
+
+	if _, err := Serve(server.Addr); err == nil {
+		t.Error("Expected an error serving on an address in use")
+	}
+}
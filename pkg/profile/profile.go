@@ -0,0 +1,231 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// PathEnv overrides the default location of the profiles file
+const PathEnv = "PLAT_V2_TOOLS_PROFILES"
+
+// EnvProfileName names the target when no profile is given and the Nomad client ENV vars are used
+const EnvProfileName = "env"
+
+// Profile is a named Nomad cluster to target
+// Relative file paths are relative to the profiles file
+type Profile struct {
+	Name       string `json:"-"`
+	Address    string `json:"address"`
+	Region     string `json:"region,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	TokenFile  string `json:"token_file,omitempty"`  // file holding the ACL token
+	CACert     string `json:"ca_cert,omitempty"`     // CA certificate to verify the servers with
+	ClientCert string `json:"client_cert,omitempty"` // client certificate for mTLS
+	ClientKey  string `json:"client_key,omitempty"`  // client key for mTLS
+	SkipVerify bool   `json:"skip_verify,omitempty"` // skip TLS verification of the servers
+}
+
+// File is a profiles file, e.g.
+//
+//	{"profiles": {"test-1": {"address": "https://127.0.0.1:4646", "skip_verify": true}}}
+type File struct {
+	Profiles map[string]*Profile `json:"profiles"`
+}
+
+// DefaultPath is $PLAT_V2_TOOLS_PROFILES, else profiles.json in the user config dir, e.g. ~/.config/plat-v2-tools/profiles.json
+func DefaultPath() string {
+	if path := os.Getenv(PathEnv); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "profiles.json"
+	}
+	return filepath.Join(dir, "plat-v2-tools", "profiles.json")
+}
+
+// Load reads a profiles file, resolving the file paths in each profile
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading profiles file %s: %w", path, err)
+	}
+
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing profiles file %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for name, p := range file.Profiles {
+		if p == nil {
+			return nil, fmt.Errorf("profile %s in %s is empty", name, path)
+		}
+		p.Name = name
+		p.TokenFile = resolvePath(dir, p.TokenFile)
+		p.CACert = resolvePath(dir, p.CACert)
+		p.ClientCert = resolvePath(dir, p.ClientCert)
+		p.ClientKey = resolvePath(dir, p.ClientKey)
+	}
+	return &file, nil
+}
+
+// resolvePath expands a leading ~ and makes a relative path relative to dir
+func resolvePath(dir, path string) string {
+	if path == "" {
+		return ""
+	}
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return path
+}
+
+// Get returns the named profile
+func (f *File) Get(name string) (*Profile, error) {
+	p, ok := f.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(f.Profiles))
+		for n := range f.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("profile %s not found, available profiles: %s", name, strings.Join(names, ", "))
+	}
+	if p.Address == "" {
+		return nil, fmt.Errorf("profile %s has no address", name)
+	}
+	return p, nil
+}
+
+// Resolve loads the named profile from the profiles file at path
+// With an empty name no file is read and the profile is left empty so the Nomad client ENV vars apply
+func Resolve(path, name string) (*Profile, error) {
+	if name == "" {
+		return &Profile{}, nil
+	}
+	file, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return file.Get(name)
+}
+
+// LoadNomadConfig resolves the named profile and returns it with its Nomad client config
+func LoadNomadConfig(path, name string) (*api.Config, *Profile, error) {
+	p, err := Resolve(path, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := p.NomadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, p, nil
+}
+
+// NomadConfig returns a Nomad client config for the profile
+// A named profile is the whole target, so none of the Nomad client ENV vars apply, e.g. a NOMAD_TOKEN exported for
+// another cluster is never sent to it. Without a name, the config comes from the ENV vars as api.DefaultConfig does
+func (p *Profile) NomadConfig() (*api.Config, error) {
+	config := api.DefaultConfig()
+	if p.Name != "" {
+		config.Region = ""
+		config.Namespace = ""
+		config.SecretID = ""
+		config.HttpAuth = nil
+		config.TLSConfig = &api.TLSConfig{}
+	}
+	if p.Address != "" {
+		config.Address = p.Address
+	}
+	if p.Region != "" {
+		config.Region = p.Region
+	}
+	if p.Namespace != "" {
+		config.Namespace = p.Namespace
+	}
+	if p.TokenFile != "" {
+		token, err := os.ReadFile(p.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading token file for profile %s: %w", p.Name, err)
+		}
+		config.SecretID = strings.TrimSpace(string(token))
+	}
+	if p.CACert != "" {
+		config.TLSConfig.CACert = p.CACert
+	}
+	if p.ClientCert != "" {
+		config.TLSConfig.ClientCert = p.ClientCert
+	}
+	if p.ClientKey != "" {
+		config.TLSConfig.ClientKey = p.ClientKey
+	}
+	if p.SkipVerify {
+		config.TLSConfig.Insecure = true
+	}
+	return config, nil
+}
+
+// Target describes the cluster a Nomad config points at, for logging at start-up
+// The ACL token itself is never included
+type Target struct {
+	Profile    string `json:"profile"`
+	Address    string `json:"address"`
+	Region     string `json:"region,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Token      bool   `json:"token"`
+	CACert     string `json:"caCert,omitempty"`
+	ClientCert string `json:"clientCert,omitempty"`
+	SkipVerify bool   `json:"skipVerify"`
+}
+
+// Target describes the cluster that config, created from the profile, points at
+func (p *Profile) Target(config *api.Config) Target {
+	name := p.Name
+	if name == "" {
+		name = EnvProfileName
+	}
+	target := Target{
+		Profile:   name,
+		Address:   config.Address,
+		Region:    config.Region,
+		Namespace: config.Namespace,
+		Token:     config.SecretID != "",
+	}
+	if config.TLSConfig != nil {
+		target.CACert = config.TLSConfig.CACert
+		target.ClientCert = config.TLSConfig.ClientCert
+		target.SkipVerify = config.TLSConfig.Insecure
+	}
+	return target
+}
+
+func (t Target) String() string {
+	s := fmt.Sprintf("profile=%s address=%s", t.Profile, t.Address)
+	if t.Region != "" {
+		s += " region=" + t.Region
+	}
+	if t.Namespace != "" {
+		s += " namespace=" + t.Namespace
+	}
+	s += fmt.Sprintf(" token=%t", t.Token)
+	if t.CACert != "" {
+		s += " caCert=" + t.CACert
+	}
+	if t.ClientCert != "" {
+		s += " clientCert=" + t.ClientCert
+	}
+	return s + fmt.Sprintf(" skipVerify=%t", t.SkipVerify)
+}
@@ -0,0 +1,147 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeProfiles(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "profiles.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write profiles file: %v", err)
+	}
+	return path
+}
+
+func TestLoadNomadConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test-1.token"), []byte("secret-token\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+	path := writeProfiles(t, dir, `{
+		"profiles": {
+			"test-1": {
+				"address": "https://10.0.0.1:4646",
+				"region": "us-west",
+				"namespace": "staging",
+				"token_file": "test-1.token",
+				"ca_cert": "/etc/nomad/ca.pem",
+				"client_cert": "certs/cli.pem",
+				"client_key": "certs/cli-key.pem",
+				"skip_verify": true
+			}
+		}
+	}`)
+
+	config, p, err := LoadNomadConfig(path, "test-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if p.Name != "test-1" || p.Namespace != "staging" {
+		t.Errorf("Unexpected profile: %+v", p)
+	}
+	if config.Address != "https://10.0.0.1:4646" || config.Region != "us-west" || config.Namespace != "staging" {
+		t.Errorf("Unexpected config target: %s %s %s", config.Address, config.Region, config.Namespace)
+	}
+	if config.SecretID != "secret-token" {
+		t.Errorf("Expected token from the token file, got %q", config.SecretID)
+	}
+	tls := config.TLSConfig
+	if tls.CACert != "/etc/nomad/ca.pem" {
+		t.Errorf("Expected absolute CA path to be kept, got %s", tls.CACert)
+	}
+	if tls.ClientCert != filepath.Join(dir, "certs/cli.pem") || tls.ClientKey != filepath.Join(dir, "certs/cli-key.pem") {
+		t.Errorf("Expected client cert and key relative to the profiles file, got %s and %s", tls.ClientCert, tls.ClientKey)
+	}
+	if !tls.Insecure {
+		t.Error("Expected skip_verify to set Insecure")
+	}
+
+	target := p.Target(config)
+	if !target.Token || target.Profile != "test-1" || !target.SkipVerify {
+		t.Errorf("Unexpected target: %+v", target)
+	}
+	if strings.Contains(target.String(), "secret-token") {
+		t.Errorf("Expected the token to never be in the target, got %s", target)
+	}
+}
+
+func TestLoadNomadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := writeProfiles(t, dir, `{
+		"profiles": {
+			"test-1": {"address": "https://10.0.0.1:4646"},
+			"no-address": {"region": "us-west"},
+			"no-token": {"address": "https://10.0.0.2:4646", "token_file": "missing.token"}
+		}
+	}`)
+
+	tests := []struct {
+		name    string
+		path    string
+		profile string
+		errText string
+	}{
+		{name: "unknown profile", path: path, profile: "test-2", errText: "available profiles: no-address, no-token, test-1"},
+		{name: "no address", path: path, profile: "no-address", errText: "has no address"},
+		{name: "missing token file", path: path, profile: "no-token", errText: "error reading token file"},
+		{name: "missing profiles file", path: filepath.Join(dir, "missing.json"), profile: "test-1", errText: "error reading profiles file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := LoadNomadConfig(tt.path, tt.profile)
+			if err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("Expected error containing %q, got %v", tt.errText, err)
+			}
+		})
+	}
+}
+
+func TestLoadNomadConfigEnv(t *testing.T) {
+	t.Setenv("NOMAD_ADDR", "https://127.0.0.1:4646")
+	t.Setenv("NOMAD_SKIP_VERIFY", "true")
+
+	// Without a profile the profiles file isn't read at all
+	config, p, err := LoadNomadConfig(filepath.Join(t.TempDir(), "missing.json"), "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	target := p.Target(config)
+	if target.Profile != EnvProfileName || target.Address != "https://127.0.0.1:4646" || !target.SkipVerify {
+		t.Errorf("Expected target from the Nomad client ENV vars, got %+v", target)
+	}
+}
+
+func TestLoadNomadConfigIgnoresEnv(t *testing.T) {
+	t.Setenv("NOMAD_TOKEN", "env-token")
+	t.Setenv("NOMAD_REGION", "env-region")
+	t.Setenv("NOMAD_NAMESPACE", "env-namespace")
+	t.Setenv("NOMAD_CACERT", "/env/ca.pem")
+	t.Setenv("NOMAD_SKIP_VERIFY", "true")
+
+	dir := t.TempDir()
+	path := writeProfiles(t, dir, `{"profiles": {"test-1": {"address": "https://10.0.0.1:4646"}}}`)
+	config, _, err := LoadNomadConfig(path, "test-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.SecretID != "" || config.Region != "" || config.Namespace != "" {
+		t.Errorf("Expected no token, region or namespace from the ENV vars, got %q, %q and %q", config.SecretID, config.Region, config.Namespace)
+	}
+	if config.TLSConfig.CACert != "" || config.TLSConfig.Insecure {
+		t.Errorf("Expected no TLS settings from the ENV vars, got %+v", config.TLSConfig)
+	}
+}
+
+func TestDefaultPath(t *testing.T) {
+	t.Setenv(PathEnv, "/tmp/profiles.json")
+	if path := DefaultPath(); path != "/tmp/profiles.json" {
+		t.Errorf("Expected path from %s, got %s", PathEnv, path)
+	}
+}
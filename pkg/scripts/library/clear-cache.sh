@@ -0,0 +1,6 @@
+# description: Flush the WordPress object cache, optionally deleting transients too
+# var: transients=false Also delete all transients, true or false
+wp cache flush
+{{- if eq .Vars.transients "true"}}
+wp transient delete --all
+{{- end}}
@@ -0,0 +1,3 @@
+# description: Disk usage of each directory under a path, largest last
+# var: path=./wp-content Directory to measure, relative to the site root
+du -sh {{quote .Vars.path}}/* 2>/dev/null | sort -h
@@ -0,0 +1,4 @@
+# description: Largest files under a path
+# var: path=./wp-content Directory to search, relative to the site root
+# var: count=20 Number of files to list
+find {{quote .Vars.path}} -type f -printf '%s\t%p\n' 2>/dev/null | sort -rn | head -n {{quote .Vars.count}}
@@ -0,0 +1,4 @@
+# description: List installed plugins with their status and version
+# var: status= Only list plugins with this status, e.g. active or inactive
+# var: format=table Output format: table, csv, json or yaml
+wp plugin list --format={{quote .Vars.format}}{{if .Vars.status}} --status={{quote .Vars.status}}{{end}}
@@ -0,0 +1,3 @@
+# description: List installed themes with their status and version
+# var: format=table Output format: table, csv, json or yaml
+wp theme list --format={{quote .Vars.format}}
@@ -0,0 +1,3 @@
+# description: Site URL and account of the job, with the allocation it ran on
+echo "job={{.JobID}} alloc={{.AllocID}} node={{.NodeID}}" account={{quote (.Meta "account_id")}}
+wp option get siteurl
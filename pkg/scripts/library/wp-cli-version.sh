@@ -0,0 +1,4 @@
+# description: WP-CLI, WordPress core and PHP versions
+wp --version
+wp core version --extra
+php -r 'echo "PHP " . PHP_VERSION . "\n";'
@@ -0,0 +1,232 @@
+// Package scripts is a library of named shell scripts embedded in the binary, rendered with text/template
+// for each job they run on. A script starts with comment lines declaring it:
+//
+//	# description: Disk usage of each directory under a path
+//	# var: path=./wp-content Directory to measure
+//
+// A var without =<default> is required. Scripts see .JobID, .AllocID, .NodeID and .Cluster of the job,
+// .Vars for their variables, {{.Meta "key"}} for the job's meta, and {{quote x}} to single-quote x for the shell
+package scripts
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+//go:embed library/*.sh
+var library embed.FS
+
+// Param is a variable a script takes
+type Param struct {
+	Name        string
+	Default     string
+	Required    bool
+	Description string
+}
+
+// Script is a named script from the library
+type Script struct {
+	Name        string
+	Description string
+	Params      []Param
+	Source      string
+	tmpl        *template.Template
+}
+
+var (
+	loadOnce sync.Once
+	scripts  map[string]*Script
+	loadErr  error
+)
+
+// load parses every script in the library once
+func load() (map[string]*Script, error) {
+	loadOnce.Do(func() {
+		scripts = map[string]*Script{}
+		files, err := fs.Glob(library, "library/*.sh")
+		if err != nil {
+			loadErr = err
+			return
+		}
+		for _, file := range files {
+			src, err := library.ReadFile(file)
+			if err != nil {
+				loadErr = err
+				return
+			}
+			script, err := Parse(strings.TrimSuffix(path.Base(file), ".sh"), string(src))
+			if err != nil {
+				loadErr = err
+				return
+			}
+			scripts[script.Name] = script
+		}
+	})
+	return scripts, loadErr
+}
+
+// List returns every script in the library sorted by name
+func List() ([]*Script, error) {
+	all, err := load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Script, 0, len(all))
+	for _, script := range all {
+		list = append(list, script)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Get returns the named script from the library
+func Get(name string) (*Script, error) {
+	all, err := load()
+	if err != nil {
+		return nil, err
+	}
+	script, ok := all[name]
+	if !ok {
+		names := make([]string, 0, len(all))
+		for n := range all {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown script %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return script, nil
+}
+
+// Parse parses a script's declaration comments and template
+func Parse(name, src string) (*Script, error) {
+	script := &Script{Name: name, Source: src}
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "#")
+		if !ok {
+			break
+		}
+		line = strings.TrimSpace(line)
+		if desc, ok := strings.CutPrefix(line, "description:"); ok {
+			script.Description = strings.TrimSpace(desc)
+		} else if decl, ok := strings.CutPrefix(line, "var:"); ok {
+			param, err := parseParam(strings.TrimSpace(decl))
+			if err != nil {
+				return nil, fmt.Errorf("script %s: %w", name, err)
+			}
+			script.Params = append(script.Params, param)
+		}
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{"quote": Quote}).Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("script %s: %w", name, err)
+	}
+	script.tmpl = tmpl
+	return script, nil
+}
+
+// parseParam parses "<name>[=<default>] <description>"
+func parseParam(decl string) (Param, error) {
+	spec, desc, _ := strings.Cut(decl, " ")
+	name, def, hasDefault := strings.Cut(spec, "=")
+	if name == "" {
+		return Param{}, fmt.Errorf("var declaration %q has no name", decl)
+	}
+	return Param{Name: name, Default: def, Required: !hasDefault, Description: strings.TrimSpace(desc)}, nil
+}
+
+// Vars returns the script's variables from vars with defaults applied
+// It fails on variables the script doesn't take and required variables that aren't given
+func (s *Script) Vars(vars map[string]string) (map[string]string, error) {
+	resolved := map[string]string{}
+	for _, p := range s.Params {
+		value, ok := vars[p.Name]
+		if !ok && p.Required {
+			return nil, fmt.Errorf("script %s requires -var %s=<value>", s.Name, p.Name)
+		}
+		if !ok {
+			value = p.Default
+		}
+		resolved[p.Name] = value
+	}
+	for name := range vars {
+		if !slices.ContainsFunc(s.Params, func(p Param) bool { return p.Name == name }) {
+			return nil, fmt.Errorf("script %s has no variable %s", s.Name, name)
+		}
+	}
+	return resolved, nil
+}
+
+// Render renders the script for a job, c.Vars should come from Vars
+func (s *Script) Render(c *Context) (string, error) {
+	var b strings.Builder
+	if err := s.tmpl.Execute(&b, c); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Context is the job a script is rendered for
+type Context struct {
+	JobID   string
+	AllocID string
+	NodeID  string
+	Cluster string
+	Vars    map[string]string
+
+	// MetaFunc looks up the job's meta, only called if the script uses .Meta
+	MetaFunc func() (map[string]string, error)
+	metaOnce sync.Once
+	meta     map[string]string
+	metaErr  error
+}
+
+// Meta returns a value of the job's meta, looking it up the first time, or an empty string if it isn't set
+func (c *Context) Meta(key string) (string, error) {
+	c.metaOnce.Do(func() {
+		if c.MetaFunc == nil {
+			c.metaErr = fmt.Errorf("job meta isn't available")
+			return
+		}
+		c.meta, c.metaErr = c.MetaFunc()
+	})
+	if c.metaErr != nil {
+		return "", fmt.Errorf("error getting meta of job %s: %w", c.JobID, c.metaErr)
+	}
+	return c.meta[key], nil
+}
+
+// Quote single-quotes s for the shell
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// VarsFlag collects repeated -var name=value flags
+type VarsFlag map[string]string
+
+func (v VarsFlag) String() string {
+	pairs := make([]string, 0, len(v))
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v VarsFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	v[name] = value
+	return nil
+}
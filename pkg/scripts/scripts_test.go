@@ -0,0 +1,121 @@
+package scripts
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLibrary(t *testing.T) {
+	list, err := List()
+	if err != nil {
+		t.Fatalf("Unexpected error loading the library: %v", err)
+	}
+	if len(list) == 0 {
+		t.Fatal("Expected scripts in the library")
+	}
+
+	// Every script renders with its defaults
+	for _, script := range list {
+		if script.Description == "" {
+			t.Errorf("Expected script %s to have a description", script.Name)
+		}
+		vars, err := script.Vars(nil)
+		if err != nil {
+			t.Errorf("Expected script %s to render with its defaults, got %v", script.Name, err)
+			continue
+		}
+		c := &Context{JobID: "app-1", AllocID: "alloc-1", NodeID: "node-1", Vars: vars,
+			MetaFunc: func() (map[string]string, error) { return map[string]string{"account_id": "acct-1"}, nil }}
+		if _, err := script.Render(c); err != nil {
+			t.Errorf("Unexpected error rendering script %s: %v", script.Name, err)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	script, err := Parse("test", `# description: Test script
+# var: path=./wp-content Directory to list
+# var: flag Required flag
+ls {{.Vars.flag}} {{quote .Vars.path}} # {{.JobID}} {{.Meta "tier"}}
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if script.Description != "Test script" || len(script.Params) != 2 || !script.Params[1].Required {
+		t.Errorf("Unexpected script declaration: %+v", script)
+	}
+
+	if _, err := script.Vars(map[string]string{}); err == nil || !strings.Contains(err.Error(), "flag") {
+		t.Errorf("Expected an error for the missing required var, got %v", err)
+	}
+	if _, err := script.Vars(map[string]string{"flag": "-l", "other": "x"}); err == nil || !strings.Contains(err.Error(), "other") {
+		t.Errorf("Expected an error for an unknown var, got %v", err)
+	}
+
+	vars, err := script.Vars(map[string]string{"flag": "-l", "path": "it's here"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	metaCalls := 0
+	c := &Context{JobID: "app-1", Vars: vars, MetaFunc: func() (map[string]string, error) {
+		metaCalls++
+		return map[string]string{"tier": "gold"}, nil
+	}}
+	out, err := script.Render(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(out, `ls -l 'it'\''s here' # app-1 gold`+"\n") {
+		t.Errorf("Unexpected render: %q", out)
+	}
+
+	// Meta is looked up once per context
+	if _, err := script.Render(c); err != nil || metaCalls != 1 {
+		t.Errorf("Expected one meta lookup, got %d and error %v", metaCalls, err)
+	}
+	c = &Context{JobID: "app-2", Vars: vars, MetaFunc: func() (map[string]string, error) { return nil, errors.New("boom") }}
+	if _, err := script.Render(c); err == nil || !strings.Contains(err.Error(), "app-2") {
+		t.Errorf("Expected the meta lookup error, got %v", err)
+	}
+}
+
+func TestLibraryQuotesMeta(t *testing.T) {
+	script, err := Get("site-info")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c := &Context{JobID: "app-1", AllocID: "alloc-1", NodeID: "node-1", Vars: map[string]string{},
+		MetaFunc: func() (map[string]string, error) { return map[string]string{"account_id": `$(rm -rf ~) "x"`}, nil }}
+	out, err := script.Render(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(out, `account='$(rm -rf ~) "x"'`) {
+		t.Errorf("Expected the account ID single-quoted, got %q", out)
+	}
+}
+
+func TestGet(t *testing.T) {
+	if _, err := Get("disk-usage"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := Get("missing"); err == nil || !strings.Contains(err.Error(), "disk-usage") {
+		t.Errorf("Expected an error listing the scripts, got %v", err)
+	}
+}
+
+func TestVarsFlag(t *testing.T) {
+	vars := VarsFlag{}
+	for _, s := range []string{"path=./wp-content", "query=a=b"} {
+		if err := vars.Set(s); err != nil {
+			t.Errorf("Unexpected error for %q: %v", s, err)
+		}
+	}
+	if vars["query"] != "a=b" || vars.String() != "path=./wp-content,query=a=b" {
+		t.Errorf("Unexpected vars: %v", vars)
+	}
+	if err := vars.Set("novalue"); err == nil {
+		t.Error("Expected an error without =")
+	}
+}
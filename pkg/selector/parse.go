@@ -0,0 +1,349 @@
+package selector
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of selector"
+	}
+	return strconv.Quote(t.text)
+}
+
+// is reports whether the token is the bare keyword kw, keywords are case insensitive
+func (t token) is(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+var keywords = []string{"and", "or", "not", "in", "exists"}
+
+func isKeyword(s string) bool {
+	for _, kw := range keywords {
+		if strings.EqualFold(s, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBareRune reports whether r can be part of an unquoted word such as a field or value
+func isBareRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-.:/@*+", r)
+}
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+func newLexer(input string) *lexer {
+	return &lexer{input: input}
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) && strings.ContainsRune(" \t\r\n", rune(l.input[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	rest := l.input[l.pos:]
+	for _, op := range []string{"==", "!=", "=~", "!~", "&&", "||"} {
+		if strings.HasPrefix(rest, op) {
+			l.pos += len(op)
+			switch op {
+			case "&&":
+				return token{kind: tokWord, text: "and", pos: start}, nil
+			case "||":
+				return token{kind: tokWord, text: "or", pos: start}, nil
+			}
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+
+	switch c := rest[0]; c {
+	case '!':
+		l.pos++
+		return token{kind: tokWord, text: "not", pos: start}, nil
+	case '(':
+		l.pos++
+		return token{kind: tokLParen, text: "(", pos: start}, nil
+	case ')':
+		l.pos++
+		return token{kind: tokRParen, text: ")", pos: start}, nil
+	case '[':
+		l.pos++
+		return token{kind: tokLBracket, text: "[", pos: start}, nil
+	case ']':
+		l.pos++
+		return token{kind: tokRBracket, text: "]", pos: start}, nil
+	case ',':
+		l.pos++
+		return token{kind: tokComma, text: ",", pos: start}, nil
+	case '"':
+		// Find the closing quote, skipping escaped characters
+		end := 1
+		for end < len(rest) && rest[end] != '"' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return token{}, fmt.Errorf("unterminated string at offset %d", start)
+		}
+		text, err := strconv.Unquote(rest[:end+1])
+		if err != nil {
+			return token{}, fmt.Errorf("invalid string at offset %d: %w", start, err)
+		}
+		l.pos += end + 1
+		return token{kind: tokString, text: text, pos: start}, nil
+	}
+
+	end := strings.IndexFunc(rest, func(r rune) bool { return !isBareRune(r) })
+	if end == 0 {
+		return token{}, fmt.Errorf("unexpected character %q at offset %d", rest[0], start)
+	}
+	if end < 0 {
+		end = len(rest)
+	}
+	l.pos += end
+	return token{kind: tokWord, text: rest[:end], pos: start}, nil
+}
+
+// parser is a recursive descent parser for:
+//
+//	or    = and { "or" and }
+//	and   = unary { "and" unary }
+//	unary = "not" unary | "(" or ")" | term
+//	term  = field ( ("==" | "!=" | "=~" | "!~") value | ["not"] "in" "[" value { "," value } "]" | ["not"] "exists" )
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []node{left}
+	for p.tok.is("or") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, right)
+	}
+	if len(nodes) == 1 {
+		return left, nil
+	}
+	return &orNode{nodes: nodes}, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []node{left}
+	for p.tok.is("and") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, right)
+	}
+	if len(nodes) == 1 {
+		return left, nil
+	}
+	return &andNode{nodes: nodes}, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	switch {
+	case p.tok.is("not"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node: n}, nil
+	case p.tok.kind == tokLParen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) but got %s at offset %d", p.tok, p.tok.pos)
+		}
+		return n, p.advance()
+	}
+	return p.parseTerm()
+}
+
+func (p *parser) parseTerm() (node, error) {
+	if p.tok.kind != tokWord || isKeyword(p.tok.text) {
+		return nil, fmt.Errorf("expected a field but got %s at offset %d", p.tok, p.tok.pos)
+	}
+	t := &term{field: strings.ToLower(p.tok.text)}
+	if strings.HasPrefix(t.field, FieldMeta+".") {
+		t.field, t.key = FieldMeta, p.tok.text[len(FieldMeta)+1:]
+	}
+	switch t.field {
+	case FieldID, FieldName, FieldType, FieldStatus, FieldDatacenter, FieldNodePool:
+	case "dc":
+		t.field = FieldDatacenter
+	case FieldMeta:
+		if t.key == "" {
+			return nil, fmt.Errorf("expected meta.<key> at offset %d", p.tok.pos)
+		}
+	default:
+		return nil, fmt.Errorf("unknown field %s at offset %d, expected id, name, type, status, datacenter, node_pool or meta.<key>", p.tok, p.tok.pos)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	negate := false
+	if p.tok.is("not") {
+		negate = true
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if !p.tok.is("in") && !p.tok.is("exists") {
+			return nil, fmt.Errorf("expected in or exists after not but got %s at offset %d", p.tok, p.tok.pos)
+		}
+	}
+
+	switch {
+	case p.tok.kind == tokOp:
+		op := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		t.values = []string{value}
+		switch op {
+		case "==", "=~":
+			t.op = op
+		case "!=":
+			t.op, negate = "==", true
+		case "!~":
+			t.op, negate = "=~", true
+		}
+		if t.op == "=~" {
+			if t.re, err = regexp.Compile(value); err != nil {
+				return nil, fmt.Errorf("invalid regex %q: %w", value, err)
+			}
+		}
+	case p.tok.is("in"):
+		t.op = "in"
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		t.values = values
+	case p.tok.is("exists"):
+		if t.field != FieldMeta {
+			return nil, fmt.Errorf("exists is only supported on meta.<key> at offset %d", p.tok.pos)
+		}
+		t.op = "exists"
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("expected an operator after %s but got %s at offset %d", t.field, p.tok, p.tok.pos)
+	}
+
+	if negate {
+		return &notNode{node: t}, nil
+	}
+	return t, nil
+}
+
+func (p *parser) parseValue() (string, error) {
+	if p.tok.kind != tokString && (p.tok.kind != tokWord || isKeyword(p.tok.text)) {
+		return "", fmt.Errorf("expected a value but got %s at offset %d", p.tok, p.tok.pos)
+	}
+	value := p.tok.text
+	return value, p.advance()
+}
+
+func (p *parser) parseList() ([]string, error) {
+	if p.tok.kind != tokLBracket {
+		return nil, fmt.Errorf("expected [ but got %s at offset %d", p.tok, p.tok.pos)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var values []string
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if p.tok.kind == tokRBracket {
+			return values, p.advance()
+		}
+		if p.tok.kind != tokComma {
+			return nil, fmt.Errorf("expected , or ] but got %s at offset %d", p.tok, p.tok.pos)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+}
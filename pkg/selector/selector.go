@@ -0,0 +1,460 @@
+// Package selector selects Nomad jobs with expressions like
+//
+//	name =~ "^app-[0-9]+$" and meta.account_id == "acct-1"
+//	status in [running, pending] and (datacenter == dc1 or node_pool != default)
+//	meta.tier exists and not meta.legacy exists
+//
+// Fields are id, name, type, status, datacenter (or dc), node_pool and meta.<key>. Operators are
+// == and != for equality, =~ and !~ for regex matches, [not] in [a, b] for set membership, and
+// [not] exists for meta keys. A datacenter term matches if any of the job's datacenters does.
+// Terms combine with and (&&), or (||), not (!) and parentheses, and values can be bare words or quoted.
+package selector
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// Fields a term can select on, meta is written as meta.<key>
+const (
+	FieldID         = "id"
+	FieldName       = "name"
+	FieldType       = "type"
+	FieldStatus     = "status"
+	FieldDatacenter = "datacenter"
+	FieldNodePool   = "node_pool"
+	FieldMeta       = "meta"
+)
+
+// Job is the view of a Nomad job that selectors are matched against
+type Job struct {
+	ID          string
+	Name        string
+	Type        string
+	Status      string
+	NodePool    string
+	Datacenters []string
+	Meta        map[string]string
+}
+
+// FromStub creates a Job from a job list stub, which has no node pool
+func FromStub(stub *api.JobListStub) *Job {
+	return &Job{
+		ID:          stub.ID,
+		Name:        stub.Name,
+		Type:        stub.Type,
+		Status:      stub.Status,
+		Datacenters: stub.Datacenters,
+		Meta:        stub.Meta,
+	}
+}
+
+// FromJob creates a Job from a full job
+func FromJob(job *api.Job) *Job {
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	return &Job{
+		ID:          deref(job.ID),
+		Name:        deref(job.Name),
+		Type:        deref(job.Type),
+		Status:      deref(job.Status),
+		NodePool:    deref(job.NodePool),
+		Datacenters: job.Datacenters,
+		Meta:        job.Meta,
+	}
+}
+
+// Selector selects Nomad jobs with an expression of terms combined with and, or, not and parentheses, e.g.
+//
+//	name =~ "^app-[0-9]+$" and (meta.tier in [gold, silver] or not meta.legacy exists) and status == running
+//
+// A nil Selector selects every job
+type Selector struct {
+	root node
+}
+
+// Parse parses a selector expression, see parser for the grammar
+func Parse(s string) (*Selector, error) {
+	p := &parser{lex: newLexer(s)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", p.tok, p.tok.pos)
+	}
+	return &Selector{root: root}, nil
+}
+
+// MustParse is Parse for selectors known to be valid, it panics on an error
+func MustParse(s string) *Selector {
+	sel, err := Parse(s)
+	if err != nil {
+		panic(fmt.Sprintf("selector %q: %v", s, err))
+	}
+	return sel
+}
+
+// And combines selectors so a job must match all of them, nil selectors are skipped
+func And(sels ...*Selector) *Selector {
+	var nodes []node
+	for _, sel := range sels {
+		if sel != nil {
+			nodes = append(nodes, sel.root)
+		}
+	}
+	switch len(nodes) {
+	case 0:
+		return nil
+	case 1:
+		return &Selector{root: nodes[0]}
+	}
+	return &Selector{root: &andNode{nodes: nodes}}
+}
+
+// Match reports whether the job is selected
+func (s *Selector) Match(j *Job) bool {
+	if s == nil {
+		return true
+	}
+	return s.root.match(j)
+}
+
+// MatchStub matches a job list stub, and reports whether the stub was enough to be certain
+// Stubs have no node pool and older servers leave the meta off them, so a selector on those needs
+// the full job unless the rest of the selector already decides the match, e.g. a name that doesn't match
+func (s *Selector) MatchStub(stub *api.JobListStub) (matched, certain bool) {
+	if s == nil {
+		return true, true
+	}
+	unknown := map[string]bool{FieldNodePool: true, FieldMeta: stub.Meta == nil}
+	return s.root.matchPartial(FromStub(stub), unknown)
+}
+
+// Filter returns a Nomad filter expression that selects at least every job the selector does, so
+// it can cut down a job list server-side before the jobs are matched locally
+// It is empty if nothing can be filtered server-side
+func (s *Selector) Filter() string {
+	if s == nil {
+		return ""
+	}
+	filter, _ := s.root.filter()
+	return filter
+}
+
+func (s *Selector) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.root.String()
+}
+
+// node is a part of a selector expression
+type node interface {
+	match(j *Job) bool
+	// matchPartial matches a job with some fields unknown, and reports whether the match is certain
+	matchPartial(j *Job, unknown map[string]bool) (matched, certain bool)
+	// filter returns the Nomad filter for the node, and whether it selects exactly the same jobs
+	// An empty filter selects every job
+	filter() (string, bool)
+	String() string
+}
+
+type andNode struct {
+	nodes []node
+}
+
+func (n *andNode) match(j *Job) bool {
+	for _, child := range n.nodes {
+		if !child.match(j) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPartial is certainly false if any child certainly doesn't match
+func (n *andNode) matchPartial(j *Job, unknown map[string]bool) (bool, bool) {
+	certain := true
+	for _, child := range n.nodes {
+		matched, childCertain := child.matchPartial(j, unknown)
+		if childCertain && !matched {
+			return false, true
+		}
+		certain = certain && childCertain
+	}
+	return certain, certain
+}
+
+// filter keeps the children that can be filtered, dropping the others only widens the selection
+func (n *andNode) filter() (string, bool) {
+	var parts []string
+	exact := true
+	for _, child := range n.nodes {
+		f, childExact := child.filter()
+		exact = exact && childExact
+		if f == "" {
+			continue
+		}
+		if _, isOr := child.(*orNode); isOr {
+			f = "(" + f + ")"
+		}
+		parts = append(parts, f)
+	}
+	return strings.Join(parts, " and "), exact
+}
+
+func (n *andNode) String() string {
+	parts := make([]string, len(n.nodes))
+	for i, child := range n.nodes {
+		parts[i] = child.String()
+		if _, isOr := child.(*orNode); isOr {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, " and ")
+}
+
+type orNode struct {
+	nodes []node
+}
+
+func (n *orNode) match(j *Job) bool {
+	for _, child := range n.nodes {
+		if child.match(j) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPartial is certainly true if any child certainly matches
+func (n *orNode) matchPartial(j *Job, unknown map[string]bool) (bool, bool) {
+	certain := true
+	for _, child := range n.nodes {
+		matched, childCertain := child.matchPartial(j, unknown)
+		if childCertain && matched {
+			return true, true
+		}
+		certain = certain && childCertain
+	}
+	return false, certain
+}
+
+// filter needs every child filtered, a child that selects every job makes the whole or select every job
+func (n *orNode) filter() (string, bool) {
+	parts := make([]string, 0, len(n.nodes))
+	exact := true
+	for _, child := range n.nodes {
+		f, childExact := child.filter()
+		if f == "" {
+			return "", false
+		}
+		exact = exact && childExact
+		parts = append(parts, f)
+	}
+	return strings.Join(parts, " or "), exact
+}
+
+func (n *orNode) String() string {
+	parts := make([]string, len(n.nodes))
+	for i, child := range n.nodes {
+		parts[i] = child.String()
+	}
+	return strings.Join(parts, " or ")
+}
+
+type notNode struct {
+	node node
+}
+
+func (n *notNode) match(j *Job) bool {
+	return !n.node.match(j)
+}
+
+func (n *notNode) matchPartial(j *Job, unknown map[string]bool) (bool, bool) {
+	matched, certain := n.node.matchPartial(j, unknown)
+	return !matched && certain, certain
+}
+
+// filter can only negate a filter that selects exactly the same jobs
+func (n *notNode) filter() (string, bool) {
+	f, exact := n.node.filter()
+	if f == "" || !exact {
+		return "", false
+	}
+	return "not (" + f + ")", true
+}
+
+func (n *notNode) String() string {
+	if t, ok := n.node.(*term); ok {
+		switch t.op {
+		case "==":
+			return t.withOp("!=")
+		case "=~":
+			return t.withOp("!~")
+		case "in":
+			return t.withOp("not in")
+		case "exists":
+			return t.withOp("not exists")
+		}
+	}
+	return "not (" + n.node.String() + ")"
+}
+
+// term compares one field of a job, negated operators are parsed into a notNode around the term
+type term struct {
+	field  string
+	key    string // meta key
+	op     string // ==, =~, in or exists
+	values []string
+	re     *regexp.Regexp
+}
+
+// values returns the values of the job field, datacenters can have several and a missing meta key has none
+func (t *term) jobValues(j *Job) []string {
+	switch t.field {
+	case FieldID:
+		return []string{j.ID}
+	case FieldName:
+		return []string{j.Name}
+	case FieldType:
+		return []string{j.Type}
+	case FieldStatus:
+		return []string{j.Status}
+	case FieldNodePool:
+		return []string{j.NodePool}
+	case FieldDatacenter:
+		return j.Datacenters
+	case FieldMeta:
+		if v, ok := j.Meta[t.key]; ok {
+			return []string{v}
+		}
+	}
+	return nil
+}
+
+// match is true if any of the job's values for the field matches
+func (t *term) match(j *Job) bool {
+	values := t.jobValues(j)
+	if t.op == "exists" {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		switch t.op {
+		case "==", "in":
+			if slices.Contains(t.values, v) {
+				return true
+			}
+		case "=~":
+			if t.re.MatchString(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var filterMetaKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (t *term) filter() (string, bool) {
+	var selector string
+	switch t.field {
+	case FieldID:
+		selector = "ID"
+	case FieldName:
+		selector = "Name"
+	case FieldType:
+		selector = "Type"
+	case FieldStatus:
+		selector = "Status"
+	case FieldMeta:
+		if !filterMetaKeyPattern.MatchString(t.key) {
+			return "", false
+		}
+		selector = "Meta." + t.key
+	case FieldDatacenter:
+		// Datacenters is a list, which can only be filtered on membership
+		if t.op == "=~" {
+			return "", false
+		}
+		parts := make([]string, len(t.values))
+		for i, v := range t.values {
+			parts[i] = strconv.Quote(v) + " in Datacenters"
+		}
+		return joinOr(parts), true
+	default:
+		// Node pools aren't on the job list stubs
+		return "", false
+	}
+
+	switch t.op {
+	case "exists":
+		return strconv.Quote(t.key) + " in Meta", true
+	case "=~":
+		return selector + " matches " + strconv.Quote(t.values[0]), true
+	}
+	parts := make([]string, len(t.values))
+	for i, v := range t.values {
+		parts[i] = selector + " == " + strconv.Quote(v)
+	}
+	return joinOr(parts), true
+}
+
+// joinOr joins filters with or, in parentheses if there is more than one so it can be combined
+func joinOr(parts []string) string {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return "(" + strings.Join(parts, " or ") + ")"
+}
+
+func (t *term) matchPartial(j *Job, unknown map[string]bool) (bool, bool) {
+	if unknown[t.field] {
+		return false, false
+	}
+	return t.match(j), true
+}
+
+func (t *term) String() string {
+	return t.withOp(t.op)
+}
+
+// withOp renders the term with op, used to render negated terms with their negated operator
+func (t *term) withOp(op string) string {
+	field := t.field
+	if field == FieldMeta {
+		field += "." + t.key
+	}
+	switch op {
+	case "exists", "not exists":
+		return field + " " + op
+	case "in", "not in":
+		values := make([]string, len(t.values))
+		for i, v := range t.values {
+			values[i] = formatValue(v)
+		}
+		return field + " " + op + " [" + strings.Join(values, ", ") + "]"
+	}
+	return field + " " + op + " " + formatValue(t.values[0])
+}
+
+// formatValue quotes a value unless it can be written as a bare word
+func formatValue(v string) string {
+	if v != "" && !isKeyword(v) && strings.IndexFunc(v, func(r rune) bool { return !isBareRune(r) }) < 0 {
+		return v
+	}
+	return strconv.Quote(v)
+}
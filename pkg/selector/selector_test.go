@@ -0,0 +1,189 @@
+package selector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+func testJobs() []*Job {
+	return []*Job{
+		{ID: "app-1", Name: "app-1", Status: "running", NodePool: "default", Datacenters: []string{"dc1"}, Meta: map[string]string{"account_id": "acct-1", "tier": "gold"}},
+		{ID: "app-2", Name: "app-2", Status: "pending", NodePool: "dense", Datacenters: []string{"dc1", "dc2"}, Meta: map[string]string{"account_id": "acct-2", "legacy": "true"}},
+		{ID: "app-3", Name: "app-3", Status: "dead", NodePool: "default", Datacenters: []string{"dc2"}, Meta: map[string]string{"account_id": "acct-1", "tier": "silver"}},
+		{ID: "cron-1", Name: "cron-1", Type: "batch", Status: "running", Datacenters: []string{"dc3"}},
+	}
+}
+
+func TestSelectorMatch(t *testing.T) {
+	tests := []struct {
+		selector string
+		expected []string
+	}{
+		{selector: `name =~ "^app-[0-9]+$"`, expected: []string{"app-1", "app-2", "app-3"}},
+		{selector: `name !~ "^app-"`, expected: []string{"cron-1"}},
+		{selector: `id == app-2`, expected: []string{"app-2"}},
+		{selector: `meta.account_id == "acct-1"`, expected: []string{"app-1", "app-3"}},
+		{selector: `meta.account_id != acct-1`, expected: []string{"app-2", "cron-1"}},
+		{selector: `meta.tier exists`, expected: []string{"app-1", "app-3"}},
+		{selector: `meta.tier not exists`, expected: []string{"app-2", "cron-1"}},
+		{selector: `meta.tier in [gold, silver]`, expected: []string{"app-1", "app-3"}},
+		{selector: `status not in [running, pending]`, expected: []string{"app-3"}},
+		{selector: `datacenter == dc2`, expected: []string{"app-2", "app-3"}},
+		{selector: `dc =~ "^dc[12]$"`, expected: []string{"app-1", "app-2", "app-3"}},
+		{selector: `node_pool == dense`, expected: []string{"app-2"}},
+		{selector: `type == batch`, expected: []string{"cron-1"}},
+		{selector: `status == running and meta.tier == gold or node_pool == dense`, expected: []string{"app-1", "app-2"}},
+		{selector: `status == running and (meta.tier == gold or node_pool == dense)`, expected: []string{"app-1"}},
+		{selector: `not (status == running) && !meta.legacy exists`, expected: []string{"app-3"}},
+		{selector: `status == running || datacenter == dc2`, expected: []string{"app-1", "app-2", "app-3", "cron-1"}},
+		{selector: `Meta.account_id == acct-2 AND Status == pending`, expected: []string{"app-2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			sel, err := Parse(tt.selector)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var matched []string
+			for _, job := range testJobs() {
+				if sel.Match(job) {
+					matched = append(matched, job.ID)
+				}
+			}
+			if strings.Join(matched, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, matched)
+			}
+
+			// The rendered selector parses back to one that selects the same jobs
+			reparsed, err := Parse(sel.String())
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %q: %v", sel.String(), err)
+			}
+			for _, job := range testJobs() {
+				if reparsed.Match(job) != sel.Match(job) {
+					t.Errorf("Expected %q to match %s the same as %q", sel.String(), job.ID, tt.selector)
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		selector string
+		errText  string
+	}{
+		{selector: ``, errText: "expected a field"},
+		{selector: `owner == bob`, errText: "unknown field"},
+		{selector: `meta. == x`, errText: "expected meta.<key>"},
+		{selector: `name =~ "["`, errText: "invalid regex"},
+		{selector: `status == running and`, errText: "expected a field"},
+		{selector: `(status == running`, errText: "expected )"},
+		{selector: `status exists`, errText: "only supported on meta"},
+		{selector: `status in [running`, errText: "expected , or ]"},
+		{selector: `status in running`, errText: "expected ["},
+		{selector: `status == "running`, errText: "unterminated string"},
+		{selector: `status not == running`, errText: "expected in or exists after not"},
+		{selector: `status == running status == dead`, errText: "unexpected"},
+		{selector: `status ~ running`, errText: "unexpected character"},
+	}
+
+	for _, tt := range tests {
+		if _, err := Parse(tt.selector); err == nil || !strings.Contains(err.Error(), tt.errText) {
+			t.Errorf("Expected error containing %q for %q, got %v", tt.errText, tt.selector, err)
+		}
+	}
+}
+
+func TestSelectorFilter(t *testing.T) {
+	tests := []struct {
+		selector string
+		expected string
+	}{
+		{selector: `name =~ "^app-[0-9]+$" and meta.account_id == "acct-1"`, expected: `Name matches "^app-[0-9]+$" and Meta.account_id == "acct-1"`},
+		{selector: `status in [running, pending]`, expected: `(Status == "running" or Status == "pending")`},
+		{selector: `datacenter == dc1`, expected: `"dc1" in Datacenters`},
+		{selector: `meta.tier exists and not meta.legacy exists`, expected: `"tier" in Meta and not ("legacy" in Meta)`},
+		{selector: `status == running or meta.tier == gold`, expected: `Status == "running" or Meta.tier == "gold"`},
+		{selector: `(status == running or meta.tier == gold) and id != app-1`, expected: `(Status == "running" or Meta.tier == "gold") and not (ID == "app-1")`},
+		// Node pools can't be filtered server-side, an and drops them and anything else loses the filter
+		{selector: `status == running and node_pool == dense`, expected: `Status == "running"`},
+		{selector: `status == running or node_pool == dense`, expected: ``},
+		{selector: `not (status == running and node_pool == dense)`, expected: ``},
+		{selector: `dc =~ "^dc"`, expected: ``},
+		{selector: `meta.odd-key == x`, expected: ``},
+	}
+
+	for _, tt := range tests {
+		sel, err := Parse(tt.selector)
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %v", tt.selector, err)
+		}
+		if filter := sel.Filter(); filter != tt.expected {
+			t.Errorf("Expected filter %q for %q, got %q", tt.expected, tt.selector, filter)
+		}
+	}
+}
+
+func TestSelectorMatchStub(t *testing.T) {
+	withMeta := &api.JobListStub{ID: "app-1", Name: "app-1", Status: "running", Meta: map[string]string{"tier": "gold"}}
+	withoutMeta := &api.JobListStub{ID: "app-2", Name: "app-2", Status: "running"}
+
+	tests := []struct {
+		selector        string
+		stub            *api.JobListStub
+		expectedMatch   bool
+		expectedCertain bool
+	}{
+		{selector: `status == running`, stub: withoutMeta, expectedMatch: true, expectedCertain: true},
+		{selector: `meta.tier == gold`, stub: withMeta, expectedMatch: true, expectedCertain: true},
+		{selector: `meta.tier == gold`, stub: withoutMeta, expectedMatch: false, expectedCertain: false},
+		{selector: `node_pool == dense`, stub: withMeta, expectedMatch: false, expectedCertain: false},
+		{selector: `status == dead and node_pool == dense`, stub: withMeta, expectedMatch: false, expectedCertain: true},
+		{selector: `status == running or meta.tier exists`, stub: withoutMeta, expectedMatch: true, expectedCertain: true},
+		{selector: `status == running and not meta.tier exists`, stub: withoutMeta, expectedMatch: false, expectedCertain: false},
+		{selector: `not (status == dead or meta.tier exists)`, stub: withMeta, expectedMatch: false, expectedCertain: true},
+	}
+
+	for _, tt := range tests {
+		matched, certain := MustParse(tt.selector).MatchStub(tt.stub)
+		if matched != tt.expectedMatch || certain != tt.expectedCertain {
+			t.Errorf("Expected MatchStub %v, %v for %q on %s, got %v, %v", tt.expectedMatch, tt.expectedCertain, tt.selector, tt.stub.ID, matched, certain)
+		}
+	}
+}
+
+func TestAnd(t *testing.T) {
+	if And(nil, nil) != nil {
+		t.Error("Expected And of nil selectors to be nil")
+	}
+	var nilSel *Selector
+	if !nilSel.Match(&Job{}) || nilSel.Filter() != "" {
+		t.Error("Expected a nil selector to select every job")
+	}
+
+	sel := And(MustParse(`status == running or status == pending`), nil, MustParse(`meta.tier == gold`))
+	if sel.String() != `(status == running or status == pending) and meta.tier == gold` {
+		t.Errorf("Unexpected combined selector: %s", sel)
+	}
+	var matched []string
+	for _, job := range testJobs() {
+		if sel.Match(job) {
+			matched = append(matched, job.ID)
+		}
+	}
+	if len(matched) != 1 || matched[0] != "app-1" {
+		t.Errorf("Expected only app-1, got %v", matched)
+	}
+}
+
+func TestFromJob(t *testing.T) {
+	id, pool, status := "app-1", "dense", "running"
+	job := FromJob(&api.Job{ID: &id, Name: &id, NodePool: &pool, Status: &status, Datacenters: []string{"dc1"}})
+	if job.ID != "app-1" || job.NodePool != "dense" || job.Status != "running" || job.Type != "" {
+		t.Errorf("Unexpected job: %+v", job)
+	}
+}
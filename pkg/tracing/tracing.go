@@ -0,0 +1,84 @@
+// Package tracing sets up OpenTelemetry tracing for the tools and starts their spans
+// Spans are no-ops unless Setup is called with an exporter
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/tcordingly-godaddy/plat-v2-tools"
+
+// Options configures where spans are exported, a run can export to both
+type Options struct {
+	ServiceName  string // service.name of the spans, e.g. backup-data-gen
+	OTLPEndpoint string // OTLP/HTTP collector URL, e.g. http://localhost:4318, empty for none
+	File         string // file to write spans to as JSON, one object per span, empty for none
+}
+
+// Setup installs a tracer provider exporting to the configured exporters, and returns the func that flushes
+// and stops it, which must be called before exiting so the last spans aren't lost
+// With no exporter configured, spans stay no-ops and the returned func does nothing
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	var providerOpts []sdktrace.TracerProviderOption
+	var closers []func() error
+	if opts.OTLPEndpoint != "" {
+		exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(opts.OTLPEndpoint))
+		if err != nil {
+			return nil, fmt.Errorf("error creating OTLP exporter: %w", err)
+		}
+		providerOpts = append(providerOpts, sdktrace.WithBatcher(exporter))
+	}
+	if opts.File != "" {
+		file, err := os.Create(opts.File)
+		if err != nil {
+			return nil, fmt.Errorf("error creating trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error creating trace file exporter: %w", err)
+		}
+		providerOpts = append(providerOpts, sdktrace.WithBatcher(exporter))
+		closers = append(closers, file.Close)
+	}
+	if len(providerOpts) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	providerOpts = append(providerOpts, sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", opts.ServiceName))))
+	provider := sdktrace.NewTracerProvider(providerOpts...)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		for _, closer := range closers {
+			err = errors.Join(err, closer())
+		}
+		return err
+	}, nil
+}
+
+// Start starts a span as a child of any span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends a span, recording err on it if it isn't nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetupFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json")
+	shutdown, err := Setup(context.Background(), Options{ServiceName: "test", File: path})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, parent := Start(context.Background(), "parent")
+	_, child := Start(ctx, "child")
+	End(child, errors.New("child failed"))
+	End(parent, nil)
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	type span struct {
+		Name        string
+		SpanContext struct{ TraceID, SpanID string }
+		Parent      struct{ SpanID string }
+		Status      struct{ Code string }
+	}
+	spans := map[string]span{}
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var s span
+		if err := decoder.Decode(&s); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		spans[s.Name] = s
+	}
+
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans in the file, got %d", len(spans))
+	}
+	if spans["child"].Parent.SpanID != spans["parent"].SpanContext.SpanID {
+		t.Errorf("Expected the child span under the parent span, got parent %s", spans["child"].Parent.SpanID)
+	}
+	if spans["child"].Status.Code != "Error" {
+		t.Errorf("Expected the child span to have an error status, got %q", spans["child"].Status.Code)
+	}
+}
+
+func TestSetupNone(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Options{ServiceName: "test"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
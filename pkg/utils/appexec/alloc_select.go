@@ -0,0 +1,88 @@
+package appexec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// AllocSelectMode chooses which of a job's running allocations a command is executed on
+type AllocSelectMode string
+
+const (
+	AllocSelectFirst  AllocSelectMode = "first"  // first running allocation returned by Nomad
+	AllocSelectNewest AllocSelectMode = "newest" // most recently created running allocation
+	AllocSelectAll    AllocSelectMode = "all"    // every running allocation
+	AllocSelectAlloc  AllocSelectMode = "alloc"  // a specific allocation ID or ID prefix
+	AllocSelectNode   AllocSelectMode = "node"   // running allocations on a specific node ID or ID prefix
+)
+
+// AllocSelector selects allocations of a job, Value holds the ID for the alloc and node modes
+type AllocSelector struct {
+	Mode  AllocSelectMode
+	Value string
+}
+
+// ParseAllocSelector parses a selector of the form first, newest, all, alloc:<id> or node:<id>
+func ParseAllocSelector(s string) (AllocSelector, error) {
+	mode, value, _ := strings.Cut(s, ":")
+	selector := AllocSelector{Mode: AllocSelectMode(mode), Value: value}
+	switch selector.Mode {
+	case AllocSelectFirst, AllocSelectNewest, AllocSelectAll:
+		if value != "" {
+			return AllocSelector{}, fmt.Errorf("alloc selector %q does not take a value", mode)
+		}
+	case AllocSelectAlloc, AllocSelectNode:
+		if value == "" {
+			return AllocSelector{}, fmt.Errorf("alloc selector %q requires an ID, e.g. %s:<id>", mode, mode)
+		}
+	default:
+		return AllocSelector{}, fmt.Errorf("unknown alloc selector %q, expected first, newest, all, alloc:<id> or node:<id>", s)
+	}
+	return selector, nil
+}
+
+func (s AllocSelector) String() string {
+	if s.Value == "" {
+		return string(s.Mode)
+	}
+	return string(s.Mode) + ":" + s.Value
+}
+
+// Select picks allocations out of a job's running allocations
+func (s AllocSelector) Select(running []*api.AllocationListStub) []*api.AllocationListStub {
+	if len(running) == 0 {
+		return nil
+	}
+
+	switch s.Mode {
+	case AllocSelectNewest:
+		newest := running[0]
+		for _, alloc := range running[1:] {
+			if alloc.CreateIndex > newest.CreateIndex {
+				newest = alloc
+			}
+		}
+		return []*api.AllocationListStub{newest}
+	case AllocSelectAll:
+		selected := append([]*api.AllocationListStub(nil), running...)
+		sort.SliceStable(selected, func(i, j int) bool { return selected[i].ID < selected[j].ID })
+		return selected
+	case AllocSelectAlloc, AllocSelectNode:
+		var selected []*api.AllocationListStub
+		for _, alloc := range running {
+			id := alloc.ID
+			if s.Mode == AllocSelectNode {
+				id = alloc.NodeID
+			}
+			if strings.HasPrefix(id, s.Value) {
+				selected = append(selected, alloc)
+			}
+		}
+		return selected
+	default:
+		return running[:1]
+	}
+}
@@ -1,35 +1,102 @@
 package appexec
 
 import (
-	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"regexp"
+	"log/slog"
+	"net/http"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/selector"
 )
 
 const (
+	SitesNamespace  = "sites"
 	AppUnitTaskName = "app-unit"
 	CustomerUser    = "customer"
 	ExecConcurrency = 5 // max concurrent execs
+
+	AccountIDMetaKey  = "account_id" // job meta key holding the customer account ID
+	FilterConcurrency = 10           // max concurrent job info lookups when filtering without server support
+	listJobsPageSize  = 500
 )
 
-// AppExec is a utility for executing commands on app containers to run commands as customer user
+// Options configures where AppExec finds app containers and how it execs into them
+type Options struct {
+	Namespace     string        // Nomad namespace of the app jobs
+	TaskName      string        // task in the allocation to exec into, e.g. app-unit, nginx or php-fpm
+	ExecUser      string        // user the command is run as inside the task
+	AllocSelector AllocSelector // which of a job's running allocations to exec on
+}
+
+// DefaultOptions returns default configuration
+func DefaultOptions() Options {
+	return Options{
+		Namespace: SitesNamespace,
+		TaskName:  AppUnitTaskName,
+		ExecUser:  CustomerUser,
+		AllocSelector: AllocSelector{
+			Mode: AllocSelectFirst,
+		},
+	}
+}
+
+// withDefaults fills any unset options from DefaultOptions
+func (o Options) withDefaults() Options {
+	defaults := DefaultOptions()
+	if o.Namespace == "" {
+		o.Namespace = defaults.Namespace
+	}
+	if o.TaskName == "" {
+		o.TaskName = defaults.TaskName
+	}
+	if o.ExecUser == "" {
+		o.ExecUser = defaults.ExecUser
+	}
+	if o.AllocSelector.Mode == "" {
+		o.AllocSelector = defaults.AllocSelector
+	}
+	return o
+}
+
+// AppExec is a utility for executing commands on app containers, by default as the customer user
 type AppExec struct {
-	NomadClient   *api.Client
-	execSemaphore chan struct{} // buffer chan to act as a semaphore for concurrent execs
+	Executor          Executor
+	Options           Options
+	MaxOutputBytes    int           // max bytes of stdout and stderr each kept in an ExecResponse, 0 for no limit
+	RetryPolicy       RetryPolicy   // retry policy applied to every Nomad call
+	FilterConcurrency int           // max concurrent job info lookups when account filtering can't be done server-side
+	AllocWait         time.Duration // how long to wait for a job with no running allocation to get one, 0 to fail at once
+	// RetryStartedExecs retries execs that fail after their command started, i.e. read stdin or wrote output,
+	// which runs the command again from the start, so only set it for idempotent commands
+	RetryStartedExecs bool
+	execSemaphore     chan struct{} // buffer chan to act as a semaphore for concurrent execs
+}
+
+// NewAppExec creates an AppExec backed by the given Nomad client
+// Unset fields in opts fall back to DefaultOptions
+func NewAppExec(nomadClient *api.Client, execConcurrency int, opts Options) *AppExec {
+	return NewAppExecWithExecutor(NewNomadExecutor(nomadClient), execConcurrency, opts)
 }
 
-func NewAppExec(nomadClient *api.Client, execConcurrency int) *AppExec {
+// NewAppExecWithExecutor creates an AppExec backed by any Executor, e.g. a FakeExecutor in tests
+func NewAppExecWithExecutor(executor Executor, execConcurrency int, opts Options) *AppExec {
 
 	return &AppExec{
-		NomadClient:   nomadClient,
-		execSemaphore: make(chan struct{}, execConcurrency),
+		Executor:          executor,
+		Options:           opts.withDefaults(),
+		RetryPolicy:       DefaultRetryPolicy(),
+		FilterConcurrency: FilterConcurrency,
+		execSemaphore:     make(chan struct{}, execConcurrency),
 	}
 }
 
@@ -37,109 +104,473 @@ func (ae *AppExec) WaitForAppExec() {
 	ae.execSemaphore <- struct{}{}
 }
 
+// WaitForAppExecContext waits for an exec slot like WaitForAppExec but gives up when ctx is done
+func (ae *AppExec) WaitForAppExecContext(ctx context.Context) error {
+	select {
+	case ae.execSemaphore <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryAppExec takes an exec slot if one is free without waiting, and reports whether it did
+func (ae *AppExec) TryAppExec() bool {
+	select {
+	case ae.execSemaphore <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
 func (ae *AppExec) ReleaseAppExec() {
 	<-ae.execSemaphore
 }
 
-// GetAppJobs finds all jobs for a specific account ID
-func (ae *AppExec) GetAppJobs(accountId string) ([]string, error) {
-	jobStubs, _, err := ae.NomadClient.Jobs().List(&api.QueryOptions{
-		Namespace:  "sites",
+// queryOptions returns the query options for reading from the configured namespace
+func (ae *AppExec) queryOptions() *api.QueryOptions {
+	return &api.QueryOptions{
+		Namespace:  ae.Options.Namespace,
 		AllowStale: true,
-	})
+	}
+}
+
+// AccountSelector selects the jobs of an account by their meta, it is nil to select every job if accountId is empty
+func AccountSelector(accountId string) *selector.Selector {
+	if accountId == "" {
+		return nil
+	}
+	return selector.MustParse(fmt.Sprintf("meta.%s == %q", AccountIDMetaKey, accountId))
+}
+
+// AppJobsSelector selects app jobs by name, of an account if accountId is set
+func AppJobsSelector(accountId string) *selector.Selector {
+	return selector.And(selector.MustParse(`name =~ "^app-[0-9]+$"`), AccountSelector(accountId))
+}
+
+// GetAppJobs finds all jobs for a specific account ID
+func (ae *AppExec) GetAppJobs(ctx context.Context, accountId string) ([]string, error) {
+	return ae.GetJobs(ctx, AppJobsSelector(accountId))
+}
+
+// GetJobs finds the IDs of all jobs matching a selector, a nil selector matches every job
+// As much of the selector as possible is filtered server-side with a filter expression, falling back to
+// listing every job when the servers can't filter. The jobs are then matched locally, looking up each job
+// with a bounded worker pool when the list stubs don't have what the selector needs, e.g. the node pool
+func (ae *AppExec) GetJobs(ctx context.Context, sel *selector.Selector) ([]string, error) {
+	start := time.Now()
+	filter := sel.Filter()
+	jobStubs, err := ae.listJobs(ctx, filter)
+	if err != nil && filter != "" && isFilterUnsupported(err) {
+		slog.Warn("Server-side job filtering unavailable, falling back to job info lookups", "filter", filter, "error", err)
+		jobStubs, err = ae.listJobs(ctx, "")
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	namePattern := regexp.MustCompile(`^app-[0-9]+$`)
 	var jobIDs []string
+	var unverifiedJobIDs []string
 	for _, job := range jobStubs {
-		if namePattern.MatchString(job.Name) {
+		// Match even if the servers filtered, rather than trust the filter was applied
+		matched, certain := sel.MatchStub(job)
+		switch {
+		case !certain:
+			// Older servers ignore the filter and leave meta off the stubs, and stubs never have the node pool
+			unverifiedJobIDs = append(unverifiedJobIDs, job.ID)
+		case matched:
 			jobIDs = append(jobIDs, job.ID)
 		}
 	}
 
-	// Filter by account ID if provided, needs to call job info for each job to get the meta
-	if accountId != "" {
-		jobIDs = ae.filterJobIDsByAccountId(jobIDs, accountId)
+	if len(unverifiedJobIDs) > 0 {
+		jobIDs = append(jobIDs, ae.filterJobIDsBySelector(ctx, unverifiedJobIDs, sel)...)
+	}
+	if sel != nil {
+		slog.Info("Found jobs matching selector", "selector", sel.String(), "numJobs", len(jobIDs), "duration", time.Since(start))
 	}
 
 	return jobIDs, nil
 }
 
-// filterJobIDsByAccountId filters job IDs by account ID
-func (ae *AppExec) filterJobIDsByAccountId(jobIDs []string, accountId string) []string {
-	start := time.Now()
-	filteredJobIDs := []string{}
-	filteredNum := 0
-	totalNum := len(jobIDs)
-	for _, jobID := range jobIDs {
-		filteredNum += 1
-		if filteredNum%50 == 0 {
-			log.Printf("Filtered %d/%d jobs so far...", filteredNum, totalNum)
-		}
-		job, _, err := ae.NomadClient.Jobs().Info(jobID, &api.QueryOptions{
-			Namespace:  "sites",
-			AllowStale: true,
+// listJobsWithMeta asks for the meta on job stubs, so jobs can be filtered by it without a JobInfo call each
+var listJobsWithMeta = &api.JobListOptions{Fields: &api.JobListFields{Meta: true}}
+
+// listJobs lists all job stubs matching a filter expression with their meta, following NextToken across pages
+func (ae *AppExec) listJobs(ctx context.Context, filter string) ([]*api.JobListStub, error) {
+	var jobStubs []*api.JobListStub
+	nextToken := ""
+	for {
+		q := ae.queryOptions()
+		q.Filter = filter
+		q.PerPage = listJobsPageSize
+		q.NextToken = nextToken
+
+		var page []*api.JobListStub
+		var meta *api.QueryMeta
+		err := ae.retry(ctx, "ListJobs", "", func() error {
+			var err error
+			page, meta, err = ae.Executor.ListJobs(ctx, listJobsWithMeta, q)
+			return err
 		})
 		if err != nil {
-			// TODO Retry?
-			log.Printf("error getting job info for job %s: %v", jobID, err)
-			continue
+			return nil, err
+		}
+		jobStubs = append(jobStubs, page...)
+
+		if meta == nil || meta.NextToken == "" {
+			return jobStubs, nil
+		}
+		nextToken = meta.NextToken
+	}
+}
+
+// isFilterUnsupported reports whether a list error means the servers rejected the filter expression
+func isFilterUnsupported(err error) bool {
+	code, ok := errorStatusCode(err)
+	return ok && code == http.StatusBadRequest
+}
+
+// filterJobIDsBySelector filters job IDs by a selector by looking up each job in parallel
+// The order of jobIDs is preserved in the result
+func (ae *AppExec) filterJobIDsBySelector(ctx context.Context, jobIDs []string, sel *selector.Selector) []string {
+	start := time.Now()
+	totalNum := len(jobIDs)
+	matches := make([]bool, totalNum)
+	var filteredNum atomic.Int64
+
+	workers := max(ae.FilterConcurrency, 1)
+	jobIndexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobIndexes {
+				jobID := jobIDs[i]
+				var job *api.Job
+				err := ae.retry(ctx, "JobInfo", jobID, func() error {
+					var err error
+					job, _, err = ae.Executor.JobInfo(ctx, jobID, ae.queryOptions())
+					return err
+				})
+				if n := filteredNum.Add(1); n%50 == 0 {
+					log.Printf("Filtered %d/%d jobs so far...", n, totalNum)
+				}
+				if err != nil {
+					log.Printf("error getting job info for job %s: %v", jobID, err)
+					continue
+				}
+				matches[i] = sel.Match(selector.FromJob(job))
+			}
+		}()
+	}
+
+dispatch:
+	for i := range jobIDs {
+		select {
+		case jobIndexes <- i:
+		case <-ctx.Done():
+			break dispatch
 		}
-		if job.Meta != nil && job.Meta["account_id"] == accountId {
+	}
+	close(jobIndexes)
+	wg.Wait()
+
+	filteredJobIDs := []string{}
+	for i, jobID := range jobIDs {
+		if matches[i] {
 			filteredJobIDs = append(filteredJobIDs, jobID)
 		}
 	}
-	fmt.Printf("Completed filtering jobs by account ID %s in %v. Found %d matching jobs out of %d total jobs.\n", accountId, time.Since(start), len(filteredJobIDs), totalNum)
+	fmt.Printf("Completed filtering jobs by selector %s in %v. Found %d matching jobs out of %d total jobs.\n", sel, time.Since(start), len(filteredJobIDs), totalNum)
 	return filteredJobIDs
 }
 
-// GetAppUnitAllocId gets the allocation ID for a given job ID and task name
-func (ae *AppExec) GetAppUnitAllocId(jobID string) (string, error) {
-	allocs, _, err := ae.NomadClient.Jobs().Allocations(jobID, false, &api.QueryOptions{
-		Namespace:  "sites",
-		AllowStale: true,
+// GetJobMeta gets the meta of a job
+func (ae *AppExec) GetJobMeta(ctx context.Context, jobID string) (map[string]string, error) {
+	var job *api.Job
+	err := ae.retry(ctx, "JobInfo", jobID, func() error {
+		var err error
+		job, _, err = ae.Executor.JobInfo(ctx, jobID, ae.queryOptions())
+		return err
 	})
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return job.Meta, nil
+}
+
+// GetAppUnitAllocs gets the allocations of a job whose configured task is running, narrowed down by the alloc selector
+// With AllocWait set, a job with no such allocation is waited on for up to AllocWait rather than failed at once
+func (ae *AppExec) GetAppUnitAllocs(ctx context.Context, jobID string) ([]*api.AllocationListStub, error) {
+	if ae.AllocWait > 0 {
+		return ae.WaitForAppUnitAllocs(ctx, jobID, 0, ae.AllocWait)
+	}
+	allocs, _, err := ae.jobAllocations(ctx, jobID, 0, 0)
+	if err != nil {
+		return nil, err
 	}
+	return ae.selectAppUnitAllocs(jobID, allocs, 0)
+}
+
+// WaitForAppUnitAllocs waits up to timeout for a job to have allocations of at least minJobVersion whose configured
+// task is running, e.g. while the job is rescheduled or after an update, and returns them narrowed down by the alloc selector
+// It watches the job's allocations with blocking queries, so it returns as soon as an allocation starts running
+// An error wrapping ErrNoRunningAlloc is returned if the timeout passes first
+func (ae *AppExec) WaitForAppUnitAllocs(ctx context.Context, jobID string, minJobVersion uint64, timeout time.Duration) ([]*api.AllocationListStub, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	deadline, _ := waitCtx.Deadline()
+
+	var index uint64
+	noAllocErr := fmt.Errorf("%w for job %s", ErrNoRunningAlloc, jobID)
+	for {
+		allocs, lastIndex, err := ae.jobAllocations(waitCtx, jobID, index, time.Until(deadline))
+		switch {
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case waitCtx.Err() != nil:
+			return nil, fmt.Errorf("%w after waiting %v", noAllocErr, timeout)
+		case err != nil:
+			return nil, err
+		}
+
+		selected, err := ae.selectAppUnitAllocs(jobID, allocs, minJobVersion)
+		if !errors.Is(err, ErrNoRunningAlloc) {
+			return selected, err
+		}
+		if index == 0 {
+			slog.Info("Waiting for a running allocation", "jobID", jobID, "task", ae.Options.TaskName, "minJobVersion", minJobVersion, "timeout", timeout)
+		}
+		noAllocErr = err
+		index = lastIndex
+	}
+}
 
+// jobAllocations lists a job's allocations, blocking until the index moves past waitIndex if it is set
+func (ae *AppExec) jobAllocations(ctx context.Context, jobID string, waitIndex uint64, waitTime time.Duration) ([]*api.AllocationListStub, uint64, error) {
+	q := ae.queryOptions()
+	q.WaitIndex = waitIndex
+	q.WaitTime = waitTime
+
+	var allocs []*api.AllocationListStub
+	var meta *api.QueryMeta
+	err := ae.retry(ctx, "JobAllocations", jobID, func() error {
+		var err error
+		allocs, meta, err = ae.Executor.JobAllocations(ctx, jobID, q)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return allocs, meta.LastIndex, nil
+}
+
+// RefreshAppUnitAlloc checks that an allocation selected for a job earlier is still running just before it is
+// exec'd into, as it may have been stopped or rescheduled since, e.g. in a long run that resolved every job up front
+// A stopped allocation is replaced by the newest one the alloc selector picks that claim accepts, waited on for up to
+// AllocWait, so callers can turn down allocations they already exec into. An error wrapping ErrAllocStopped is
+// returned if claim accepts none of them
+func (ae *AppExec) RefreshAppUnitAlloc(ctx context.Context, jobID string, alloc *api.AllocationListStub, claim func(*api.AllocationListStub) bool) (*api.AllocationListStub, error) {
+	allocs, _, err := ae.jobAllocations(ctx, jobID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, current := range allocs {
+		if current.ID == alloc.ID && ae.taskRunning(current) {
+			return current, nil
+		}
+	}
+
+	slog.Info("Allocation no longer running, resolving job again", "jobID", jobID, "allocID", alloc.ID)
+	selected, err := ae.GetAppUnitAllocs(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	selected = slices.Clone(selected)
+	slices.SortStableFunc(selected, func(a, b *api.AllocationListStub) int { return cmp.Compare(b.CreateIndex, a.CreateIndex) })
+	for _, current := range selected {
+		if claim(current) {
+			return current, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s of job %s, its running allocations are all taken", ErrAllocStopped, alloc.ID, jobID)
+}
+
+// SelectAppUnitAllocs narrows down allocations of a job already listed elsewhere, e.g. in an inventory snapshot,
+// to those whose configured task is running and that the alloc selector selects
+func (ae *AppExec) SelectAppUnitAllocs(jobID string, allocs []*api.AllocationListStub) ([]*api.AllocationListStub, error) {
+	return ae.selectAppUnitAllocs(jobID, allocs, 0)
+}
+
+// taskRunning reports whether an allocation has the configured task and it is running
+func (ae *AppExec) taskRunning(alloc *api.AllocationListStub) bool {
+	taskState, ok := alloc.TaskStates[ae.Options.TaskName]
+	return ok && taskState.State == "running"
+}
+
+// selectAppUnitAllocs picks the allocations of at least minJobVersion whose configured task is running with the alloc selector
+func (ae *AppExec) selectAppUnitAllocs(jobID string, allocs []*api.AllocationListStub, minJobVersion uint64) ([]*api.AllocationListStub, error) {
+	var running []*api.AllocationListStub
 	for _, alloc := range allocs {
 		// Verify app task exists and is running
-		taskState, ok := alloc.TaskStates[AppUnitTaskName]
-		if !ok {
-			continue
-		}
-		if taskState.State != "running" {
+		if !ae.taskRunning(alloc) || alloc.JobVersion < minJobVersion {
 			continue
 		}
-		return alloc.ID, nil
+		running = append(running, alloc)
 	}
-	return "", fmt.Errorf("no running allocation found for job %s", jobID)
+	if len(running) == 0 {
+		return nil, fmt.Errorf("%w for job %s", ErrNoRunningAlloc, jobID)
+	}
+
+	selector := ae.Options.AllocSelector
+	selected := selector.Select(running)
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w matching %s for job %s", ErrNoRunningAlloc, selector, jobID)
+	}
+	if selector.Mode == AllocSelectAlloc && len(selected) > 1 {
+		return nil, fmt.Errorf("allocation ID prefix %s is ambiguous for job %s", selector.Value, jobID)
+	}
+	return selected, nil
 }
 
-// ExecuteCommandOnApp executes a command on a job's running allocation as a specific user
-func (ae *AppExec) ExecuteCommandOnApp(ctx context.Context, jobID, command string) (*ExecResponse, error) {
-	// Find the running allocation for this job and task
-	allocID, err := ae.GetAppUnitAllocId(jobID)
+// GetAppUnitAllocId gets the ID of the first allocation selected for a job whose configured task is running
+func (ae *AppExec) GetAppUnitAllocId(ctx context.Context, jobID string) (string, error) {
+	allocs, err := ae.GetAppUnitAllocs(ctx, jobID)
+	if err != nil {
+		return "", err
+	}
+	return allocs[0].ID, nil
+}
+
+// AllocExecResult is the outcome of executing a command on one allocation of a job
+type AllocExecResult struct {
+	JobID     string
+	AllocID   string
+	NodeID    string
+	StartTime time.Time
+	EndTime   time.Time
+	Response  *ExecResponse
+	Err       error
+}
+
+// ExecuteCommandOnApp executes a command as the configured user on each selected running allocation of a job
+// An error is only returned if no allocation could be selected, exec errors are reported per allocation
+func (ae *AppExec) ExecuteCommandOnApp(ctx context.Context, jobID, command string) ([]*AllocExecResult, error) {
+	// Find the running allocations for this job and task
+	allocs, err := ae.GetAppUnitAllocs(ctx, jobID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get allocation ID: %w", err)
 	}
-	// Build the command to run as the specified user
-	execCommand := execAsCustomerCommand()
+	results := make([]*AllocExecResult, 0, len(allocs))
+	for _, alloc := range allocs {
+		results = append(results, ae.ExecuteCommandOnAlloc(ctx, jobID, alloc, command))
+	}
+	return results, nil
+}
+
+// ExecuteCommandOnAlloc executes a command as the configured user on an allocation already selected for a job
+func (ae *AppExec) ExecuteCommandOnAlloc(ctx context.Context, jobID string, alloc *api.AllocationListStub, command string) *AllocExecResult {
+	// A seekable reader lets the exec be retried from the start of the command
+	return ae.ExecuteStdinOnAlloc(ctx, jobID, alloc, strings.NewReader(command))
+}
 
-	reader := strings.NewReader(command)
+// ExecuteStdinOnAlloc executes the commands read from stdin as the configured user on an allocation already selected
+// for a job, streaming them to the shell as they are read
+// The exec is only retried on transient errors if stdin implements io.Seeker
+func (ae *AppExec) ExecuteStdinOnAlloc(ctx context.Context, jobID string, alloc *api.AllocationListStub, stdin io.Reader) *AllocExecResult {
+	// Build the command to run as the specified user
+	execCommand := execAsUserCommand(ae.Options.ExecUser)
 	// Execute the command on the allocation
-	return ae.ExecCommandOnAllocation(ctx, allocID, execCommand, reader)
+	start := time.Now()
+	resp, err := ae.ExecCommandOnAllocation(ctx, alloc.ID, execCommand, stdin)
+	return &AllocExecResult{
+		JobID:     jobID,
+		AllocID:   alloc.ID,
+		NodeID:    alloc.NodeID,
+		StartTime: start,
+		EndTime:   time.Now(),
+		Response:  resp,
+		Err:       err,
+	}
 }
 
 // ExecCommandOnAllocation executes a command on a Nomad allocation
+// Output is logged line by line as it streams back and kept in memory up to MaxOutputBytes
+// The exec is only retried on transient errors if reader implements io.Seeker so stdin can be replayed, and only
+// if it failed before the command started unless RetryStartedExecs is set
 func (ae *AppExec) ExecCommandOnAllocation(ctx context.Context, allocID string, command []string, reader io.Reader) (*ExecResponse, error) {
 	// Get allocation info to verify it's running
-	alloc, _, err := ae.NomadClient.Allocations().Info(allocID, &api.QueryOptions{
-		Namespace:  "sites",
-		AllowStale: true,
+	alloc, err := ae.getAllocation(ctx, allocID)
+	if err != nil {
+		return nil, err
+	}
+
+	execRetryPolicy := ae.RetryPolicy
+	seeker, canReplay := reader.(io.Seeker)
+	if !canReplay {
+		execRetryPolicy.MaxAttempts = 1
+	}
+
+	var resp *ExecResponse
+	err = execRetryPolicy.Do(ctx, "Exec", allocID, func() error {
+		if canReplay {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to rewind stdin: %w", err)
+			}
+		}
+
+		// Execute the command, streaming output to the log while keeping a copy for the response
+		stdin := &countingReader{r: reader}
+		stdout := &cappedBuffer{max: ae.MaxOutputBytes}
+		stderr := &cappedBuffer{max: ae.MaxOutputBytes}
+		stdoutLog := newLineLogger(alloc.JobID, "stdout", slog.LevelInfo)
+		stderrLog := newLineLogger(alloc.JobID, "stderr", slog.LevelError)
+
+		exitCode, err := ae.Executor.Exec(
+			ctx,
+			alloc,
+			ae.Options.TaskName,
+			false, // allocate pty
+			command,
+			stdin,
+			io.MultiWriter(stdoutLog, stdout),
+			io.MultiWriter(stderrLog, stderr),
+			nil,
+			ae.queryOptions(),
+		)
+		stdoutLog.Flush()
+		stderrLog.Flush()
+		if err != nil {
+			return ae.startedExecErr(err, stdin, stdout, stderr)
+		}
+
+		resp = &ExecResponse{
+			ExitCode:    exitCode,
+			Stdout:      stdout.String(),
+			Stderr:      stderr.String(),
+			Truncated:   stdout.truncated || stderr.truncated,
+			StdinBytes:  stdin.n,
+			OutputBytes: stdout.total + stderr.total,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exec failed: %w", err)
+	}
+
+	return resp, nil
+}
+
+// getAllocation gets the full allocation needed to exec into it
+func (ae *AppExec) getAllocation(ctx context.Context, allocID string) (*api.Allocation, error) {
+	var alloc *api.Allocation
+	err := ae.retry(ctx, "AllocationInfo", allocID, func() error {
+		var err error
+		alloc, _, err = ae.Executor.AllocationInfo(ctx, allocID, ae.queryOptions())
+		return err
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get allocation info: %w", err)
@@ -147,52 +578,63 @@ func (ae *AppExec) ExecCommandOnAllocation(ctx context.Context, allocID string,
 	if alloc == nil {
 		return nil, fmt.Errorf("allocation not found: %s", allocID)
 	}
+	return alloc, nil
+}
 
-	// Execute the command
-	stdout := new(bytes.Buffer)
-	stderr := new(bytes.Buffer)
+// ExecShellOnAllocation opens an interactive login shell as the configured user on an allocation with a PTY
+// Terminal size changes sent on sizeCh are forwarded to the remote PTY. The exec is never retried
+// since interactive stdin can't be replayed
+func (ae *AppExec) ExecShellOnAllocation(ctx context.Context, allocID string, stdin io.Reader, stdout, stderr io.Writer, sizeCh <-chan api.TerminalSize) (int, error) {
+	alloc, err := ae.getAllocation(ctx, allocID)
+	if err != nil {
+		return -1, err
+	}
 
-	exitCode, err := ae.NomadClient.Allocations().Exec(
+	exitCode, err := ae.Executor.Exec(
 		ctx,
 		alloc,
-		AppUnitTaskName,
-		false, // allocate pty
-		command,
-		reader,
+		ae.Options.TaskName,
+		true, // allocate pty
+		loginShellCommand(ae.Options.ExecUser),
+		stdin,
 		stdout,
 		stderr,
-		nil,
-		&api.QueryOptions{
-			Namespace:  "sites",
-			AllowStale: true,
-		},
+		sizeCh,
+		ae.queryOptions(),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("exec failed: %w", err)
+		return exitCode, fmt.Errorf("exec failed: %w", err)
 	}
-
-	return &ExecResponse{
-		ExitCode: exitCode,
-		Stdout:   stdout.String(),
-		Stderr:   stderr.String(),
-	}, nil
+	return exitCode, nil
 }
 
 // ExecResponse represents the result of an exec command
 type ExecResponse struct {
-	ExitCode int
-	Stdout   string
-	Stderr   string
+	ExitCode    int
+	Stdout      string
+	Stderr      string
+	Truncated   bool  // true if stdout or stderr exceeded MaxOutputBytes
+	StdinBytes  int64 // bytes of stdin sent to the command
+	OutputBytes int64 // bytes of stdout and stderr received, including any not kept due to MaxOutputBytes
 }
 
-// buildExecAsUserCommand creates the command array to execute as a specific user
-func execAsCustomerCommand() []string {
+// execAsUserCommand creates the command array to execute as a specific user
+func execAsUserCommand(user string) []string {
 	execCmd := []string{
 		"su",
 		"-l",
-		CustomerUser,
+		user,
 		"-c",
 		"/bin/bash",
 	}
 	return execCmd
 }
+
+// loginShellCommand creates the command array to open a login shell as a specific user
+func loginShellCommand(user string) []string {
+	return []string{
+		"su",
+		"-l",
+		user,
+	}
+}
@@ -0,0 +1,486 @@
+package appexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/selector"
+)
+
+func TestGetAppJobs(t *testing.T) {
+	tests := []struct {
+		name             string
+		accountID        string
+		legacyListJobs   bool
+		rejectFilter     bool
+		expected         []string
+		expectedJobInfos int
+	}{
+		{
+			name:             "all app jobs without account filter",
+			accountID:        "",
+			expected:         []string{"app-1", "app-2", "app-3", "app-4"},
+			expectedJobInfos: 0,
+		},
+		{
+			name:             "server-side account filter",
+			accountID:        "acc-1",
+			expected:         []string{"app-1", "app-3"},
+			expectedJobInfos: 0,
+		},
+		{
+			name:             "legacy servers fall back to job info and skip jobs with errors",
+			accountID:        "acc-1",
+			legacyListJobs:   true,
+			expected:         []string{"app-1"},
+			expectedJobInfos: 4,
+		},
+		{
+			name:             "rejected filter falls back to listing meta without a filter",
+			accountID:        "acc-1",
+			rejectFilter:     true,
+			expected:         []string{"app-1", "app-3"},
+			expectedJobInfos: 1, // app-4 has no meta so it can't be told apart from a legacy stub
+		},
+		{
+			name:             "no matching account",
+			accountID:        "acc-9",
+			expected:         []string{},
+			expectedJobInfos: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := NewFakeExecutor()
+			fake.AddAppJob("app-1", map[string]string{"account_id": "acc-1"})
+			fake.AddAppJob("app-2", map[string]string{"account_id": "acc-2"})
+			fake.AddAppJob("app-3", map[string]string{"account_id": "acc-1"})
+			fake.AddAppJob("app-4", nil)
+			fake.AddAppJob("other-job", map[string]string{"account_id": "acc-1"})
+			fake.JobInfoErrs["app-3"] = errors.New("boom")
+			fake.LegacyListJobs = tt.legacyListJobs
+			fake.RejectFilter = tt.rejectFilter
+
+			appExec := NewAppExecWithExecutor(fake, 1, Options{})
+			jobIDs, err := appExec.GetAppJobs(context.Background(), tt.accountID)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(jobIDs) != len(tt.expected) {
+				t.Fatalf("Expected jobs %v, got %v", tt.expected, jobIDs)
+			}
+			for i := range jobIDs {
+				if jobIDs[i] != tt.expected[i] {
+					t.Errorf("Expected job %s at index %d, got %s", tt.expected[i], i, jobIDs[i])
+				}
+			}
+			if fake.MethodCalls["JobInfo"] != tt.expectedJobInfos {
+				t.Errorf("Expected %d job info calls, got %d", tt.expectedJobInfos, fake.MethodCalls["JobInfo"])
+			}
+		})
+	}
+}
+
+func TestGetAppJobsPaging(t *testing.T) {
+	fake := NewFakeExecutor()
+	numJobs := listJobsPageSize*2 + 10
+	for i := range numJobs {
+		fake.AddAppJob(fmt.Sprintf("app-%d", i), map[string]string{"account_id": "acc-1"})
+	}
+
+	appExec := NewAppExecWithExecutor(fake, 1, Options{})
+	jobIDs, err := appExec.GetAppJobs(context.Background(), "acc-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(jobIDs) != numJobs {
+		t.Errorf("Expected %d jobs across pages, got %d", numJobs, len(jobIDs))
+	}
+	if fake.MethodCalls["ListJobs"] != 3 {
+		t.Errorf("Expected 3 list pages, got %d", fake.MethodCalls["ListJobs"])
+	}
+}
+
+func TestGetJobs(t *testing.T) {
+	tests := []struct {
+		name             string
+		selector         string
+		rejectFilter     bool
+		expected         []string
+		expectedJobInfos int
+	}{
+		{
+			name:     "status filtered server-side",
+			selector: `status == running`,
+			expected: []string{"app-1", "other-job"},
+		},
+		{
+			name:     "meta exists and datacenter matched locally",
+			selector: `meta.tier exists and dc == dc2`,
+			expected: []string{"app-2"},
+		},
+		{
+			name:             "node pool needs job info",
+			selector:         `name =~ "^app-" and node_pool == dense`,
+			expected:         []string{"app-2"},
+			expectedJobInfos: 2,
+		},
+		{
+			name:         "rejected filter falls back to matching locally",
+			selector:     `status == running and meta.tier in [gold, silver]`,
+			rejectFilter: true,
+			expected:     []string{"app-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := NewFakeExecutor()
+			fake.AddAppJob("app-1", map[string]string{"tier": "gold"})
+			fake.AddAppJob("app-2", map[string]string{"tier": "bronze"})
+			fake.AddAppJob("other-job", map[string]string{})
+			for id, status := range map[string]string{"app-1": "running", "app-2": "pending", "other-job": "running"} {
+				fake.Jobs[id].Status = &status
+			}
+			fake.Jobs["app-1"].Datacenters = []string{"dc1"}
+			fake.Jobs["app-2"].Datacenters = []string{"dc1", "dc2"}
+			pool := "dense"
+			fake.Jobs["app-2"].NodePool = &pool
+			fake.RejectFilter = tt.rejectFilter
+
+			appExec := NewAppExecWithExecutor(fake, 1, Options{})
+			jobIDs, err := appExec.GetJobs(context.Background(), selector.MustParse(tt.selector))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if strings.Join(jobIDs, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected jobs %v, got %v", tt.expected, jobIDs)
+			}
+			if fake.MethodCalls["JobInfo"] != tt.expectedJobInfos {
+				t.Errorf("Expected %d job info calls, got %d", tt.expectedJobInfos, fake.MethodCalls["JobInfo"])
+			}
+		})
+	}
+}
+
+func TestGetAppUnitAllocId(t *testing.T) {
+	fake := NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	fake.Allocs["app-2"] = []*api.AllocationListStub{
+		{ID: "pending", JobID: "app-2", TaskStates: map[string]*api.TaskState{AppUnitTaskName: {State: "pending"}}},
+		{ID: "sidecar", JobID: "app-2", TaskStates: map[string]*api.TaskState{"nginx": {State: "running"}}},
+	}
+
+	appExec := NewAppExecWithExecutor(fake, 1, Options{})
+
+	allocID, err := appExec.GetAppUnitAllocId(context.Background(), "app-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if allocID != "app-1-alloc" {
+		t.Errorf("Expected alloc app-1-alloc, got %s", allocID)
+	}
+
+	if _, err := appExec.GetAppUnitAllocId(context.Background(), "app-2"); err == nil {
+		t.Error("Expected error for job without a running app-unit task")
+	}
+}
+
+func TestWaitForAppUnitAllocs(t *testing.T) {
+	setState := func(state string) func(alloc *api.AllocationListStub) {
+		return func(alloc *api.AllocationListStub) { alloc.TaskStates[AppUnitTaskName].State = state }
+	}
+
+	t.Run("alloc starts running", func(t *testing.T) {
+		fake := NewFakeExecutor()
+		fake.AddAppJob("app-1", nil)
+		fake.UpdateAllocs("app-1", setState("pending"))
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			fake.UpdateAllocs("app-1", setState("running"))
+		}()
+
+		appExec := NewAppExecWithExecutor(fake, 1, Options{})
+		appExec.AllocWait = 5 * time.Second
+		allocID, err := appExec.GetAppUnitAllocId(context.Background(), "app-1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if allocID != "app-1-alloc" {
+			t.Errorf("Expected alloc app-1-alloc, got %s", allocID)
+		}
+		// One list to find no running alloc, then blocking lists until the update
+		if calls := fake.MethodCalls["JobAllocations"]; calls > 3 {
+			t.Errorf("Expected the wait to block rather than poll, got %d list calls", calls)
+		}
+	})
+
+	t.Run("new job version", func(t *testing.T) {
+		fake := NewFakeExecutor()
+		fake.AddAppJob("app-1", nil)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			fake.UpdateAllocs("app-1", func(alloc *api.AllocationListStub) { alloc.JobVersion = 1 })
+		}()
+
+		appExec := NewAppExecWithExecutor(fake, 1, Options{})
+		allocs, err := appExec.WaitForAppUnitAllocs(context.Background(), "app-1", 1, 5*time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(allocs) != 1 || allocs[0].JobVersion != 1 {
+			t.Errorf("Expected the alloc at job version 1, got %+v", allocs)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		fake := NewFakeExecutor()
+		fake.AddAppJob("app-1", nil)
+		fake.UpdateAllocs("app-1", setState("pending"))
+
+		appExec := NewAppExecWithExecutor(fake, 1, Options{})
+		_, err := appExec.WaitForAppUnitAllocs(context.Background(), "app-1", 0, 50*time.Millisecond)
+		if !errors.Is(err, ErrNoRunningAlloc) || ClassifyError(err) != ErrorClassNoAlloc {
+			t.Errorf("Expected a no running alloc error after the timeout, got %v", err)
+		}
+	})
+
+	t.Run("run deadline", func(t *testing.T) {
+		fake := NewFakeExecutor()
+		fake.AddAppJob("app-1", nil)
+		fake.UpdateAllocs("app-1", setState("pending"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		appExec := NewAppExecWithExecutor(fake, 1, Options{})
+		_, err := appExec.WaitForAppUnitAllocs(ctx, "app-1", 0, 5*time.Second)
+		if ClassifyError(err) != ErrorClassTimeout {
+			t.Errorf("Expected the run deadline to end the wait, got %v", err)
+		}
+	})
+}
+
+func TestExecuteCommandOnApp(t *testing.T) {
+	fake := NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	fake.Results["app-1"] = FakeExecResult{ExitCode: 3, Stdout: "out", Stderr: "err"}
+
+	appExec := NewAppExecWithExecutor(fake, 1, Options{})
+	results, err := appExec.ExecuteCommandOnApp(context.Background(), "app-1", "echo hi")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("Expected 1 successful result, got %+v", results)
+	}
+	resp := results[0].Response
+	if resp.ExitCode != 3 || resp.Stdout != "out" || resp.Stderr != "err" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+
+	calls := fake.ExecCalls()
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 exec call, got %d", len(calls))
+	}
+	if calls[0].Stdin != "echo hi" {
+		t.Errorf("Expected stdin 'echo hi', got %q", calls[0].Stdin)
+	}
+	if calls[0].Task != AppUnitTaskName {
+		t.Errorf("Expected task %s, got %s", AppUnitTaskName, calls[0].Task)
+	}
+	if len(calls[0].Command) < 3 || calls[0].Command[2] != CustomerUser {
+		t.Errorf("Expected command to run as %s, got %v", CustomerUser, calls[0].Command)
+	}
+}
+
+func TestExecuteCommandOnAppMaxOutputBytes(t *testing.T) {
+	fake := NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	fake.Results["app-1"] = FakeExecResult{Stdout: "line one\nline two\n", Stderr: "oops\n"}
+
+	appExec := NewAppExecWithExecutor(fake, 1, Options{})
+	appExec.MaxOutputBytes = 8
+	results, err := appExec.ExecuteCommandOnApp(context.Background(), "app-1", "echo hi")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp := results[0].Response
+	if resp.Stdout != "line one" {
+		t.Errorf("Expected stdout capped to 'line one', got %q", resp.Stdout)
+	}
+	if resp.Stderr != "oops\n" {
+		t.Errorf("Expected full stderr, got %q", resp.Stderr)
+	}
+	if !resp.Truncated {
+		t.Error("Expected response to be marked truncated")
+	}
+}
+
+func TestExecuteCommandOnAppOptions(t *testing.T) {
+	fake := NewFakeExecutor()
+	fake.Jobs["app-1"] = &api.Job{}
+	fake.Allocs["app-1"] = []*api.AllocationListStub{
+		{ID: "alloc-1", JobID: "app-1", TaskStates: map[string]*api.TaskState{
+			AppUnitTaskName: {State: "pending"},
+			"nginx":         {State: "running"},
+		}},
+	}
+
+	appExec := NewAppExecWithExecutor(fake, 1, Options{Namespace: "sites-staging", TaskName: "nginx", ExecUser: "root"})
+	if _, err := appExec.ExecuteCommandOnApp(context.Background(), "app-1", "nginx -T"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	calls := fake.ExecCalls()
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 exec call, got %d", len(calls))
+	}
+	if calls[0].Namespace != "sites-staging" {
+		t.Errorf("Expected namespace sites-staging, got %s", calls[0].Namespace)
+	}
+	if calls[0].Task != "nginx" {
+		t.Errorf("Expected task nginx, got %s", calls[0].Task)
+	}
+	if calls[0].Command[2] != "root" {
+		t.Errorf("Expected command to run as root, got %v", calls[0].Command)
+	}
+}
+
+func TestDefaultOptions(t *testing.T) {
+	appExec := NewAppExecWithExecutor(NewFakeExecutor(), 1, Options{TaskName: "php-fpm"})
+	expected := Options{Namespace: SitesNamespace, TaskName: "php-fpm", ExecUser: CustomerUser, AllocSelector: AllocSelector{Mode: AllocSelectFirst}}
+	if appExec.Options != expected {
+		t.Errorf("Expected options %+v, got %+v", expected, appExec.Options)
+	}
+}
+
+func TestExecuteCommandOnAppAllocSelector(t *testing.T) {
+	running := map[string]*api.TaskState{AppUnitTaskName: {State: "running"}}
+	newFake := func() *FakeExecutor {
+		fake := NewFakeExecutor()
+		fake.Jobs["app-1"] = &api.Job{}
+		fake.Allocs["app-1"] = []*api.AllocationListStub{
+			{ID: "b-alloc", JobID: "app-1", NodeID: "node-2", CreateIndex: 20, TaskStates: running},
+			{ID: "a-alloc", JobID: "app-1", NodeID: "node-1", CreateIndex: 10, TaskStates: running},
+			{ID: "c-alloc", JobID: "app-1", NodeID: "node-2", CreateIndex: 30, TaskStates: map[string]*api.TaskState{AppUnitTaskName: {State: "dead"}}},
+			{ID: "d-alloc", JobID: "app-1", NodeID: "node-1", CreateIndex: 15, TaskStates: running},
+		}
+		return fake
+	}
+
+	tests := []struct {
+		name     string
+		selector string
+		expected []string
+		wantErr  bool
+	}{
+		{name: "first", selector: "first", expected: []string{"b-alloc"}},
+		{name: "newest running", selector: "newest", expected: []string{"b-alloc"}},
+		{name: "all running", selector: "all", expected: []string{"a-alloc", "b-alloc", "d-alloc"}},
+		{name: "specific alloc prefix", selector: "alloc:d-", expected: []string{"d-alloc"}},
+		{name: "specific node", selector: "node:node-1", expected: []string{"a-alloc", "d-alloc"}},
+		{name: "alloc not running", selector: "alloc:c-alloc", wantErr: true},
+		{name: "ambiguous alloc prefix", selector: "alloc:", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := ParseAllocSelector(tt.selector)
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatalf("Unexpected parse error: %v", err)
+				}
+				return
+			}
+
+			fake := newFake()
+			appExec := NewAppExecWithExecutor(fake, 1, Options{AllocSelector: selector})
+			results, err := appExec.ExecuteCommandOnApp(context.Background(), "app-1", "echo hi")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected error selecting allocations")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(results) != len(tt.expected) {
+				t.Fatalf("Expected %d results, got %d", len(tt.expected), len(results))
+			}
+			for i, result := range results {
+				if result.AllocID != tt.expected[i] {
+					t.Errorf("Expected alloc %s at index %d, got %s", tt.expected[i], i, result.AllocID)
+				}
+				if result.Err != nil || result.Response == nil {
+					t.Errorf("Expected successful exec on %s, got error %v", result.AllocID, result.Err)
+				}
+			}
+			if len(fake.ExecCalls()) != len(tt.expected) {
+				t.Errorf("Expected %d exec calls, got %d", len(tt.expected), len(fake.ExecCalls()))
+			}
+		})
+	}
+}
+
+func TestParseAllocSelector(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{input: "first"},
+		{input: "newest"},
+		{input: "all"},
+		{input: "alloc:abc123"},
+		{input: "node:def456"},
+		{input: "all:abc", wantErr: true},
+		{input: "node:", wantErr: true},
+		{input: "random", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			selector, err := ParseAllocSelector(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && selector.String() != tt.input {
+				t.Errorf("Expected selector to round trip to %s, got %s", tt.input, selector.String())
+			}
+		})
+	}
+}
+
+func TestExecShellOnAllocation(t *testing.T) {
+	fake := NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	fake.Results["app-1"] = FakeExecResult{ExitCode: 130}
+
+	appExec := NewAppExecWithExecutor(fake, 1, Options{})
+	exitCode, err := appExec.ExecShellOnAllocation(context.Background(), "app-1-alloc", strings.NewReader("exit\n"), io.Discard, io.Discard, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitCode != 130 {
+		t.Errorf("Expected exit code 130, got %d", exitCode)
+	}
+
+	calls := fake.ExecCalls()
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 exec call, got %d", len(calls))
+	}
+	if !calls[0].TTY {
+		t.Error("Expected a PTY to be allocated")
+	}
+	expected := []string{"su", "-l", CustomerUser}
+	if strings.Join(calls[0].Command, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected command %v, got %v", expected, calls[0].Command)
+	}
+}
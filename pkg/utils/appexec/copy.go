@@ -0,0 +1,456 @@
+package appexec
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// CopyResult describes the files copied to or from an app container
+type CopyResult struct {
+	AllocID string
+	Files   int   // number of regular files copied and verified
+	Bytes   int64 // total bytes of regular file content copied
+}
+
+// CopyToApp copies a local file or directory into remoteDir on a job's app container
+// The copy is streamed as a tar archive through exec stdin so modes and mtimes are preserved,
+// then every file's sha256 checksum is verified on the container
+func (ae *AppExec) CopyToApp(ctx context.Context, jobID, localPath, remoteDir string) (*CopyResult, error) {
+	allocID, err := ae.GetAppUnitAllocId(ctx, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get allocation ID: %w", err)
+	}
+	alloc, err := ae.getAllocation(ctx, allocID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stream the tar archive as it is written, the checksums are only complete once the exec has read it all
+	pipeReader, pipeWriter := io.Pipe()
+	type archiveResult struct {
+		checksums map[string]string
+		bytes     int64
+		err       error
+	}
+	archived := make(chan archiveResult, 1)
+	go func() {
+		checksums, n, err := writeTar(pipeWriter, localPath)
+		pipeWriter.CloseWithError(err)
+		archived <- archiveResult{checksums: checksums, bytes: n, err: err}
+	}()
+
+	// The directory is made in an exec of its own, which can be retried, as the archive stream can't be replayed
+	exitCode, _, stderr, err := ae.execScript(ctx, alloc, "mkdir -p "+shellQuote(remoteDir), "", maxLogLineBytes)
+	if err != nil {
+		pipeReader.Close()
+		<-archived
+		return nil, fmt.Errorf("exec failed: %w", err)
+	}
+	if exitCode != 0 {
+		pipeReader.Close()
+		<-archived
+		return nil, fmt.Errorf("remote mkdir exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+
+	stderr = &cappedBuffer{max: maxLogLineBytes}
+	script := "tar -xpf - -C " + shellQuote(remoteDir)
+	exitCode, err = ae.Executor.Exec(ctx, alloc, ae.Options.TaskName, false, shellCommand(ae.Options.ExecUser, script),
+		pipeReader, io.Discard, stderr, nil, ae.queryOptions())
+	pipeReader.Close()
+	result := <-archived
+	if err != nil {
+		return nil, fmt.Errorf("exec failed: %w", err)
+	}
+	if result.err != nil {
+		return nil, fmt.Errorf("failed to archive %s: %w", localPath, result.err)
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("remote tar extract exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+	checksums := result.checksums
+
+	// Verify the checksums of the extracted files on the container
+	if len(checksums) > 0 {
+		script = fmt.Sprintf("cd %s && sha256sum -c --quiet -", shellQuote(remoteDir))
+		var stdout *cappedBuffer
+		exitCode, stdout, stderr, err = ae.execScript(ctx, alloc, script, formatChecksums(checksums), maxLogLineBytes)
+		if err != nil {
+			return nil, fmt.Errorf("exec failed: %w", err)
+		}
+		if exitCode != 0 {
+			return nil, fmt.Errorf("checksum verification failed on allocation %s: %s", allocID, strings.TrimSpace(stdout.String()+stderr.String()))
+		}
+	}
+
+	return &CopyResult{
+		AllocID: allocID,
+		Files:   len(checksums),
+		Bytes:   result.bytes,
+	}, nil
+}
+
+// CopyFromApp copies a file or directory at remotePath on a job's app container into localDir
+// The copy is streamed as a tar archive through exec stdout so modes and mtimes are preserved,
+// and every extracted file's sha256 checksum is compared against checksums taken on the container
+func (ae *AppExec) CopyFromApp(ctx context.Context, jobID, remotePath, localDir string) (*CopyResult, error) {
+	allocID, err := ae.GetAppUnitAllocId(ctx, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get allocation ID: %w", err)
+	}
+	alloc, err := ae.getAllocation(ctx, allocID)
+	if err != nil {
+		return nil, err
+	}
+
+	remotePath = strings.TrimSuffix(remotePath, "/")
+	remoteParent, remoteBase := path.Dir(remotePath), path.Base(remotePath)
+
+	// Take the checksums on the container first, keeping all of the output as it lists every file
+	script := fmt.Sprintf("cd %s && find %s -type f -exec sha256sum {} +", shellQuote(remoteParent), shellQuote(remoteBase))
+	exitCode, stdout, stderr, err := ae.execScript(ctx, alloc, script, "", 0)
+	if err != nil {
+		return nil, fmt.Errorf("exec failed: %w", err)
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("remote checksum exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+	remoteChecksums, err := parseChecksums(&stdout.buf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stream the tar archive straight into the local extractor
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		return nil, err
+	}
+	pipeReader, pipeWriter := io.Pipe()
+	type extractResult struct {
+		checksums map[string]string
+		bytes     int64
+		err       error
+	}
+	extracted := make(chan extractResult, 1)
+	go func() {
+		checksums, n, err := extractTar(pipeReader, localDir)
+		// Drain anything left so the exec doesn't block writing to a closed pipe
+		io.Copy(io.Discard, pipeReader)
+		extracted <- extractResult{checksums: checksums, bytes: n, err: err}
+	}()
+
+	// Not retried, as the archive has already been partly extracted by the time most failures are seen
+	stderr = &cappedBuffer{max: maxLogLineBytes}
+	script = fmt.Sprintf("tar -cf - -C %s %s", shellQuote(remoteParent), shellQuote(remoteBase))
+	exitCode, err = ae.Executor.Exec(ctx, alloc, ae.Options.TaskName, false, shellCommand(ae.Options.ExecUser, script),
+		strings.NewReader(""), pipeWriter, stderr, nil, ae.queryOptions())
+	pipeWriter.Close()
+	result := <-extracted
+	if err != nil {
+		return nil, fmt.Errorf("exec failed: %w", err)
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("remote tar create exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+	if result.err != nil {
+		return nil, fmt.Errorf("failed to extract tar: %w", result.err)
+	}
+
+	if err := compareChecksums(remoteChecksums, result.checksums); err != nil {
+		return nil, err
+	}
+	return &CopyResult{
+		AllocID: allocID,
+		Files:   len(result.checksums),
+		Bytes:   result.bytes,
+	}, nil
+}
+
+// execScript runs a short script on an allocation with the retry policy, replaying stdin and collecting the output
+// afresh on each attempt, stdout is capped at maxStdout bytes unless it is 0
+// As with ExecCommandOnAllocation, an attempt that read stdin or received output is only retried with RetryStartedExecs
+func (ae *AppExec) execScript(ctx context.Context, alloc *api.Allocation, script, stdin string, maxStdout int) (int, *cappedBuffer, *cappedBuffer, error) {
+	var exitCode int
+	var stdout, stderr *cappedBuffer
+	err := ae.retry(ctx, "Exec", alloc.ID, func() error {
+		in := &countingReader{r: strings.NewReader(stdin)}
+		stdout = &cappedBuffer{max: maxStdout}
+		stderr = &cappedBuffer{max: maxLogLineBytes}
+		var err error
+		exitCode, err = ae.Executor.Exec(ctx, alloc, ae.Options.TaskName, false, shellCommand(ae.Options.ExecUser, script),
+			in, stdout, stderr, nil, ae.queryOptions())
+		if err != nil {
+			return ae.startedExecErr(err, in, stdout, stderr)
+		}
+		return nil
+	})
+	return exitCode, stdout, stderr, err
+}
+
+// writeTar writes localPath and everything under it to w as a tar archive with paths relative to its parent
+// Returns the sha256 checksum of each regular file keyed by archive path and the total bytes of file content
+func writeTar(w io.Writer, localPath string) (map[string]string, int64, error) {
+	tw := tar.NewWriter(w)
+	checksums := map[string]string{}
+	var total int64
+	root := filepath.Dir(filepath.Clean(localPath))
+
+	err := filepath.WalkDir(localPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(tw, h), f)
+		if err != nil {
+			return err
+		}
+		checksums[hdr.Name] = hex.EncodeToString(h.Sum(nil))
+		total += n
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return checksums, total, tw.Close()
+}
+
+// extractTar extracts a tar archive into dir, preserving modes and mtimes
+// Everything is written through an os.Root of dir and no entry is extracted through a symlink, so links made by
+// earlier entries can't point later ones outside of it
+// Returns the sha256 checksum of each regular file keyed by archive path and the total bytes of file content
+func extractTar(r io.Reader, dir string) (map[string]string, int64, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer root.Close()
+
+	tr := tar.NewReader(r)
+	checksums := map[string]string{}
+	var total int64
+	var dirs []*tar.Header
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, 0, fmt.Errorf("refusing to extract %s outside of %s", hdr.Name, dir)
+		}
+		localName := filepath.FromSlash(name)
+		target := filepath.Join(dir, localName)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			// Keep directories writable until everything is extracted, their real mode is set at the end
+			if err := mkdirAllInRoot(root, name, 0o700); err != nil {
+				return nil, 0, err
+			}
+			dirs = append(dirs, hdr)
+		case tar.TypeReg:
+			if err := mkdirAllInRoot(root, path.Dir(name), 0o755); err != nil {
+				return nil, 0, err
+			}
+			// Replace a symlink rather than write through it
+			if info, err := root.Lstat(localName); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+				if err := root.Remove(localName); err != nil {
+					return nil, 0, err
+				}
+			}
+			f, err := root.OpenFile(localName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return nil, 0, err
+			}
+			h := sha256.New()
+			n, err := io.Copy(io.MultiWriter(f, h), tr)
+			if err == nil {
+				err = f.Chmod(hdr.FileInfo().Mode().Perm())
+			}
+			f.Close()
+			if err != nil {
+				return nil, 0, err
+			}
+			// The path has no symlinks in it, so this can't change a file outside of dir
+			if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+				return nil, 0, err
+			}
+			checksums[name] = hex.EncodeToString(h.Sum(nil))
+			total += n
+		case tar.TypeSymlink:
+			// Links that obviously escape dir are refused up front, the rest can't be followed by later entries
+			linkTarget := path.Join(path.Dir(name), hdr.Linkname)
+			if path.IsAbs(hdr.Linkname) || linkTarget == ".." || strings.HasPrefix(linkTarget, "../") {
+				return nil, 0, fmt.Errorf("refusing to extract symlink %s pointing outside of %s", hdr.Name, dir)
+			}
+			if err := mkdirAllInRoot(root, path.Dir(name), 0o755); err != nil {
+				return nil, 0, err
+			}
+			root.Remove(localName)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	// Deepest directories first so setting a parent's mtime isn't undone by changes to its children
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name := path.Clean(dirs[i].Name)
+		// A later entry may have replaced the directory with a symlink, which chmod and chtimes would follow
+		if info, err := root.Lstat(filepath.FromSlash(name)); err != nil || !info.IsDir() {
+			return nil, 0, fmt.Errorf("refusing to set mode of %s, it is no longer a directory", dirs[i].Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.Chmod(target, dirs[i].FileInfo().Mode().Perm()); err != nil {
+			return nil, 0, err
+		}
+		if err := os.Chtimes(target, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return nil, 0, err
+		}
+	}
+	return checksums, total, nil
+}
+
+// mkdirAllInRoot creates a slash separated directory and any missing parents in root
+// Every component must be a real directory, not a symlink, so nothing is extracted through a link
+func mkdirAllInRoot(root *os.Root, name string, perm fs.FileMode) error {
+	if name == "." {
+		return nil
+	}
+	current := ""
+	for _, part := range strings.Split(name, "/") {
+		current = path.Join(current, part)
+		localName := filepath.FromSlash(current)
+		if err := root.Mkdir(localName, perm); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		info, err := root.Lstat(localName)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("refusing to extract through %s, it is not a directory", current)
+		}
+	}
+	return nil
+}
+
+// formatChecksums formats checksums in the sha256sum check file format
+func formatChecksums(checksums map[string]string) string {
+	names := make([]string, 0, len(checksums))
+	for name := range checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s  %s\n", checksums[name], name)
+	}
+	return sb.String()
+}
+
+// parseChecksums parses sha256sum output into checksums keyed by path
+func parseChecksums(r io.Reader) (map[string]string, error) {
+	checksums := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, "  ")
+		if !ok {
+			return nil, fmt.Errorf("invalid checksum line: %q", line)
+		}
+		checksums[path.Clean(name)] = sum
+	}
+	return checksums, scanner.Err()
+}
+
+// compareChecksums checks every remote file was extracted locally with the same checksum
+func compareChecksums(remote, local map[string]string) error {
+	var mismatched []string
+	for name, sum := range remote {
+		if local[name] != sum {
+			mismatched = append(mismatched, name)
+		}
+	}
+	for name := range local {
+		if _, ok := remote[name]; !ok {
+			mismatched = append(mismatched, name)
+		}
+	}
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		return fmt.Errorf("checksum verification failed for %d files: %s", len(mismatched), strings.Join(mismatched, ", "))
+	}
+	return nil
+}
+
+// shellCommand creates the command array to run a shell script as a specific user, leaving stdin free for data
+func shellCommand(user, script string) []string {
+	return []string{
+		"su",
+		"-l",
+		user,
+		"-c",
+		script,
+	}
+}
+
+// shellQuote quotes a string for use as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
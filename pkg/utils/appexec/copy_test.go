@@ -0,0 +1,228 @@
+package appexec
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTarRoundTrip(t *testing.T) {
+	srcRoot := t.TempDir()
+	src := filepath.Join(srcRoot, "plugin")
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := map[string]os.FileMode{
+		"plugin.php":        0o644,
+		"bin/run.sh":        0o755,
+		"assets/css/a.css":  0o600,
+		"assets/empty.json": 0o644,
+	}
+	for name, mode := range files {
+		filePath := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte("content of "+name), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(filePath, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filePath, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var archive bytes.Buffer
+	written, writtenBytes, err := writeTar(&archive, src)
+	if err != nil {
+		t.Fatalf("Unexpected error writing tar: %v", err)
+	}
+	if len(written) != len(files) {
+		t.Errorf("Expected %d checksums, got %d", len(files), len(written))
+	}
+
+	dst := t.TempDir()
+	extracted, extractedBytes, err := extractTar(&archive, dst)
+	if err != nil {
+		t.Fatalf("Unexpected error extracting tar: %v", err)
+	}
+	if writtenBytes != extractedBytes {
+		t.Errorf("Expected %d bytes extracted, got %d", writtenBytes, extractedBytes)
+	}
+	if err := compareChecksums(written, extracted); err != nil {
+		t.Errorf("Unexpected checksum mismatch: %v", err)
+	}
+
+	for name, mode := range files {
+		info, err := os.Stat(filepath.Join(dst, "plugin", filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("Expected %s to be extracted: %v", name, err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("Expected mode %v for %s, got %v", mode, name, info.Mode().Perm())
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("Expected mtime %v for %s, got %v", mtime, name, info.ModTime())
+		}
+	}
+}
+
+func TestExtractTarRejectsPathTraversal(t *testing.T) {
+	tests := []struct {
+		name string
+		hdr  *tar.Header
+	}{
+		{name: "parent directory", hdr: &tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0o644}},
+		{name: "absolute path", hdr: &tar.Header{Name: "/etc/evil", Typeflag: tar.TypeReg, Mode: 0o644}},
+		{name: "escaping symlink", hdr: &tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var archive bytes.Buffer
+			tw := tar.NewWriter(&archive)
+			if err := tw.WriteHeader(tt.hdr); err != nil {
+				t.Fatal(err)
+			}
+			tw.Close()
+
+			if _, _, err := extractTar(&archive, t.TempDir()); err == nil {
+				t.Error("Expected extraction outside of the target directory to be refused")
+			}
+		})
+	}
+}
+
+func TestExtractTarRejectsSymlinkChain(t *testing.T) {
+	// Each link points inside the directory on its own, but e resolves to its parent through d
+	entries := []*tar.Header{
+		{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "."},
+		{Name: "e", Typeflag: tar.TypeSymlink, Linkname: "d/.."},
+		{Name: "e/escaped", Typeflag: tar.TypeReg, Mode: 0o644, Size: 4},
+	}
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, hdr := range entries {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte("evil"))
+		}
+	}
+	tw.Close()
+
+	parent := t.TempDir()
+	dst := filepath.Join(parent, "dst")
+	if err := os.Mkdir(dst, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := extractTar(&archive, dst); err == nil {
+		t.Error("Expected extraction through the symlink chain to be refused")
+	}
+	if _, err := os.Lstat(filepath.Join(parent, "escaped")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected no file written outside of the target directory, got %v", err)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	checksums := map[string]string{"site/b.txt": "bbb", "site/a.txt": "aaa"}
+	formatted := formatChecksums(checksums)
+	if formatted != "aaa  site/a.txt\nbbb  site/b.txt\n" {
+		t.Errorf("Unexpected sha256sum format: %q", formatted)
+	}
+
+	parsed, err := parseChecksums(strings.NewReader(formatted))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := compareChecksums(checksums, parsed); err != nil {
+		t.Errorf("Unexpected mismatch after round trip: %v", err)
+	}
+
+	parsed["site/b.txt"] = "ccc"
+	parsed["site/c.txt"] = "ddd"
+	err = compareChecksums(checksums, parsed)
+	if err == nil || !strings.Contains(err.Error(), "site/b.txt") || !strings.Contains(err.Error(), "site/c.txt") {
+		t.Errorf("Expected mismatch for site/b.txt and site/c.txt, got %v", err)
+	}
+}
+
+func TestCopyToApp(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(src, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	// The mkdir and the verify execs fail to start once each and are retried
+	fake.OnceErrs["Exec"] = []error{syscall.ECONNRESET, nil, nil, syscall.ECONNRESET}
+	appExec := NewAppExecWithExecutor(fake, 1, Options{})
+	appExec.RetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	result, err := appExec.CopyToApp(context.Background(), "app-1", src, "wp-content/uploads")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Files != 1 || result.Bytes != 5 {
+		t.Errorf("Expected 1 file of 5 bytes, got %+v", result)
+	}
+
+	calls := fake.ExecCalls()
+	if len(calls) != 5 {
+		t.Fatalf("Expected mkdir, extract and verify execs with a retry of mkdir and verify, got %d", len(calls))
+	}
+	if script := calls[1].Command[4]; !strings.Contains(script, "mkdir -p 'wp-content/uploads'") {
+		t.Errorf("Unexpected mkdir script: %s", script)
+	}
+	if script := calls[2].Command[4]; !strings.Contains(script, "tar -xpf - -C 'wp-content/uploads'") {
+		t.Errorf("Unexpected extract script: %s", script)
+	}
+	tr := tar.NewReader(strings.NewReader(calls[2].Stdin))
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "notes.txt" {
+		t.Errorf("Expected tar stdin with notes.txt, got %v, %v", hdr, err)
+	}
+	if script := calls[4].Command[4]; !strings.Contains(script, "sha256sum -c") {
+		t.Errorf("Unexpected verify script: %s", script)
+	}
+	if !strings.HasSuffix(calls[4].Stdin, "  notes.txt\n") {
+		t.Errorf("Expected checksum file for notes.txt replayed on retry, got %q", calls[4].Stdin)
+	}
+}
+
+func TestCopyFromAppRetriesChecksums(t *testing.T) {
+	fake := NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	fake.OnceErrs["Exec"] = []error{syscall.ECONNRESET}
+	appExec := NewAppExecWithExecutor(fake, 1, Options{})
+	appExec.RetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	// An empty directory on the container, the fake rejects execs with no stdin as the Nomad client would panic on them
+	result, err := appExec.CopyFromApp(context.Background(), "app-1", "wp-content/empty", t.TempDir())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Files != 0 {
+		t.Errorf("Expected no files, got %+v", result)
+	}
+	calls := fake.ExecCalls()
+	if len(calls) != 3 || !strings.Contains(calls[1].Command[4], "sha256sum") || !strings.Contains(calls[2].Command[4], "tar -cf") {
+		t.Errorf("Expected the checksum exec retried then the tar exec, got %+v", calls)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if got := shellQuote("it's here"); got != `'it'\''s here'` {
+		t.Errorf("Unexpected quoting: %s", got)
+	}
+}
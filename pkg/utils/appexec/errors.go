@@ -0,0 +1,46 @@
+package appexec
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoRunningAlloc is returned when a job has no allocation with the configured task running
+var ErrNoRunningAlloc = errors.New("no running allocation found")
+
+// ErrAllocStopped is returned when an allocation selected for a job stopped and no other allocation can take its place
+var ErrAllocStopped = errors.New("allocation stopped")
+
+// ErrorClass groups exec errors by cause for reporting
+type ErrorClass string
+
+const (
+	ErrorClassNone      ErrorClass = ""                 // no error
+	ErrorClassExitCode  ErrorClass = "exit_code"        // the command ran but exited with a failure code
+	ErrorClassNoAlloc   ErrorClass = "no_running_alloc" // the job had no running allocation to exec on
+	ErrorClassStopped   ErrorClass = "alloc_stopped"    // the allocation stopped before its exec and had no free replacement
+	ErrorClassTimeout   ErrorClass = "timeout"          // the exec or run deadline passed
+	ErrorClassCancelled ErrorClass = "cancelled"        // the run was cancelled, e.g. by a signal
+	ErrorClassTransient ErrorClass = "transient"        // a retryable Nomad error that persisted through every retry
+	ErrorClassPermanent ErrorClass = "permanent"        // a Nomad error that isn't worth retrying, e.g. 404 or ACL denied
+)
+
+// ClassifyError returns the error class of an error from AppExec
+func ClassifyError(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ErrorClassNone
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCancelled
+	case errors.Is(err, ErrNoRunningAlloc):
+		return ErrorClassNoAlloc
+	case errors.Is(err, ErrAllocStopped):
+		return ErrorClassStopped
+	case IsRetryable(err):
+		return ErrorClassTransient
+	default:
+		return ErrorClassPermanent
+	}
+}
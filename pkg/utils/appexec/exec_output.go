@@ -0,0 +1,95 @@
+package appexec
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+)
+
+const maxLogLineBytes = 64 * 1024 // partial lines longer than this are logged without waiting for a newline
+
+// lineLogger is an io.Writer that logs remote exec output line by line as it arrives
+type lineLogger struct {
+	jobID  string
+	stream string
+	level  slog.Level
+	buf    []byte
+}
+
+func newLineLogger(jobID, stream string, level slog.Level) *lineLogger {
+	return &lineLogger{
+		jobID:  jobID,
+		stream: stream,
+		level:  level,
+	}
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.log(l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	if len(l.buf) >= maxLogLineBytes {
+		l.Flush()
+	}
+	return len(p), nil
+}
+
+// Flush logs any remaining partial line
+func (l *lineLogger) Flush() {
+	if len(l.buf) > 0 {
+		l.log(l.buf)
+		l.buf = nil
+	}
+}
+
+func (l *lineLogger) log(line []byte) {
+	slog.Log(context.Background(), l.level, "Exec output", "jobID", l.jobID, "stream", l.stream, "line", string(bytes.TrimRight(line, "\r")))
+}
+
+// cappedBuffer keeps at most max bytes of output in memory, 0 keeps everything
+type cappedBuffer struct {
+	max       int
+	buf       bytes.Buffer
+	truncated bool
+	total     int64 // total bytes written, including any dropped
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	c.total += int64(n)
+	if c.max > 0 {
+		remaining := c.max - c.buf.Len()
+		if remaining < len(p) {
+			c.truncated = true
+			if remaining <= 0 {
+				return n, nil
+			}
+			p = p[:remaining]
+		}
+	}
+	c.buf.Write(p)
+	return n, nil
+}
+
+func (c *cappedBuffer) String() string {
+	return c.buf.String()
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,37 @@
+package appexec
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLineLogger(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	l := newLineLogger("app-1", "stdout", slog.LevelInfo)
+	l.Write([]byte("first\nsec"))
+	l.Write([]byte("ond\r\nthird"))
+	if strings.Contains(logs.String(), "third") {
+		t.Error("Partial line should not be logged before flush")
+	}
+	l.Flush()
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	expected := []string{"line=first", "line=second", "line=third"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d log lines, got %d: %s", len(expected), len(lines), logs.String())
+	}
+	for i, want := range expected {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("Expected log line %d to end with %s, got %s", i, want, lines[i])
+		}
+		if !strings.Contains(lines[i], "jobID=app-1") || !strings.Contains(lines[i], "stream=stdout") {
+			t.Errorf("Expected log line %d to be tagged with jobID and stream, got %s", i, lines[i])
+		}
+	}
+}
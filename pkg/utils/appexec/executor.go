@@ -0,0 +1,102 @@
+package appexec
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/metrics"
+	"github.com/tcordingly-godaddy/plat-v2-tools/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Executor is the set of Nomad operations AppExec needs to find and exec into app containers
+type Executor interface {
+	// ListJobs lists the job stubs matching the query options, with the extra fields asked for in opts
+	ListJobs(ctx context.Context, opts *api.JobListOptions, q *api.QueryOptions) ([]*api.JobListStub, *api.QueryMeta, error)
+	// JobInfo gets the full job definition for a job ID
+	JobInfo(ctx context.Context, jobID string, q *api.QueryOptions) (*api.Job, *api.QueryMeta, error)
+	// JobAllocations lists the allocations for a job ID
+	JobAllocations(ctx context.Context, jobID string, q *api.QueryOptions) ([]*api.AllocationListStub, *api.QueryMeta, error)
+	// ListAllocations lists the allocation stubs of every job matching the query options
+	ListAllocations(ctx context.Context, q *api.QueryOptions) ([]*api.AllocationListStub, *api.QueryMeta, error)
+	// ListNodes lists the client nodes of the cluster
+	ListNodes(ctx context.Context, q *api.QueryOptions) ([]*api.NodeListStub, *api.QueryMeta, error)
+	// AllocationInfo gets the full allocation for an allocation ID
+	AllocationInfo(ctx context.Context, allocID string, q *api.QueryOptions) (*api.Allocation, *api.QueryMeta, error)
+	// Exec runs a command in a task of an allocation and returns the remote exit code
+	Exec(ctx context.Context, alloc *api.Allocation, task string, tty bool, command []string,
+		stdin io.Reader, stdout, stderr io.Writer, sizeCh <-chan api.TerminalSize, q *api.QueryOptions) (int, error)
+}
+
+// StartNomadCall starts the trace span of a Nomad call, e.g. Jobs.List, and returns its context with the func
+// that ends the span and records the call in the metrics once it returns, deferred with a pointer to its error
+func StartNomadCall(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, func(*error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "nomad "+method, append(attrs, attribute.String("nomad.method", method))...)
+	return ctx, func(err *error) {
+		metrics.ObserveNomadCall(method, start, *err)
+		tracing.End(span, *err)
+	}
+}
+
+// NomadExecutor is the default Executor backed by a Nomad API client
+type NomadExecutor struct {
+	Client *api.Client
+}
+
+func NewNomadExecutor(client *api.Client) *NomadExecutor {
+	return &NomadExecutor{
+		Client: client,
+	}
+}
+
+// ListJobs leaves the meta off the stubs unless opts asks for it, and servers that don't support it always do
+func (ne *NomadExecutor) ListJobs(ctx context.Context, opts *api.JobListOptions, q *api.QueryOptions) (stubs []*api.JobListStub, meta *api.QueryMeta, err error) {
+	ctx, done := StartNomadCall(ctx, "Jobs.List")
+	defer done(&err)
+	return ne.Client.Jobs().ListOptions(opts, q.WithContext(ctx))
+}
+
+func (ne *NomadExecutor) JobInfo(ctx context.Context, jobID string, q *api.QueryOptions) (job *api.Job, meta *api.QueryMeta, err error) {
+	ctx, done := StartNomadCall(ctx, "Jobs.Info", attribute.String("job.id", jobID))
+	defer done(&err)
+	return ne.Client.Jobs().Info(jobID, q.WithContext(ctx))
+}
+
+func (ne *NomadExecutor) JobAllocations(ctx context.Context, jobID string, q *api.QueryOptions) (allocs []*api.AllocationListStub, meta *api.QueryMeta, err error) {
+	ctx, done := StartNomadCall(ctx, "Jobs.Allocations", attribute.String("job.id", jobID))
+	defer done(&err)
+	return ne.Client.Jobs().Allocations(jobID, false, q.WithContext(ctx))
+}
+
+func (ne *NomadExecutor) ListAllocations(ctx context.Context, q *api.QueryOptions) (allocs []*api.AllocationListStub, meta *api.QueryMeta, err error) {
+	ctx, done := StartNomadCall(ctx, "Allocations.List")
+	defer done(&err)
+	return ne.Client.Allocations().List(q.WithContext(ctx))
+}
+
+func (ne *NomadExecutor) ListNodes(ctx context.Context, q *api.QueryOptions) (nodes []*api.NodeListStub, meta *api.QueryMeta, err error) {
+	ctx, done := StartNomadCall(ctx, "Nodes.List")
+	defer done(&err)
+	return ne.Client.Nodes().List(q.WithContext(ctx))
+}
+
+func (ne *NomadExecutor) AllocationInfo(ctx context.Context, allocID string, q *api.QueryOptions) (alloc *api.Allocation, meta *api.QueryMeta, err error) {
+	ctx, done := StartNomadCall(ctx, "Allocations.Info", attribute.String("alloc.id", allocID))
+	defer done(&err)
+	return ne.Client.Allocations().Info(allocID, q.WithContext(ctx))
+}
+
+// Exec isn't counted as a Nomad call, execs have their own metrics by outcome, but it is traced
+func (ne *NomadExecutor) Exec(ctx context.Context, alloc *api.Allocation, task string, tty bool, command []string,
+	stdin io.Reader, stdout, stderr io.Writer, sizeCh <-chan api.TerminalSize, q *api.QueryOptions) (code int, err error) {
+	ctx, span := tracing.Start(ctx, "nomad Allocations.Exec", attribute.String("nomad.method", "Allocations.Exec"),
+		attribute.String("alloc.id", alloc.ID), attribute.String("node.id", alloc.NodeID), attribute.String("task", task))
+	defer func() {
+		span.SetAttributes(attribute.Int("exit_code", code))
+		tracing.End(span, err)
+	}()
+	return ne.Client.Allocations().Exec(ctx, alloc, task, tty, command, stdin, stdout, stderr, sizeCh, q)
+}
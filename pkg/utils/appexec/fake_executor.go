@@ -0,0 +1,451 @@
+package appexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// FakeExecResult is the scripted outcome of an exec on a FakeExecutor
+type FakeExecResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Err      error
+}
+
+// FakeExecCall records a single exec made against a FakeExecutor
+type FakeExecCall struct {
+	Namespace string
+	JobID     string
+	AllocID   string
+	NodeID    string
+	Task      string
+	TTY       bool
+	Command   []string
+	Stdin     string
+}
+
+// FakeExecutor is an in-memory Executor for tests that records execs and returns scripted results
+type FakeExecutor struct {
+	mu sync.Mutex
+
+	Jobs            map[string]*api.Job
+	Allocs          map[string][]*api.AllocationListStub
+	Nodes           map[string]*api.NodeListStub
+	Index           uint64                    // Raft index returned by list calls, bumped by each change made through the fake's methods
+	JobInfoErrs     map[string]error          // errors returned by JobInfo keyed by job ID
+	OnceErrs        map[string][]error        // errors returned once each, in order, keyed by method name, Exec's before reading stdin
+	StartedExecErrs []error                   // errors returned once each, in order, by execs after reading stdin and writing output
+	Results         map[string]FakeExecResult // exec results keyed by job ID
+	Calls           []FakeExecCall
+	MethodCalls     map[string]int // number of calls keyed by method name
+	MaxInFlight     map[string]int // highest number of concurrent execs seen keyed by node ID
+	inFlight        map[string]int
+
+	// ExecDelay makes each exec take this long unless its context is done first
+	ExecDelay time.Duration
+
+	// LegacyListJobs simulates servers without job list filtering, the filter is ignored and stubs carry no meta
+	// even when asked for, which otherwise they only carry if the list options ask for it
+	LegacyListJobs bool
+	// RejectFilter simulates servers that reject the filter expression with a 400
+	RejectFilter bool
+}
+
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{
+		Jobs:        map[string]*api.Job{},
+		Allocs:      map[string][]*api.AllocationListStub{},
+		Nodes:       map[string]*api.NodeListStub{},
+		JobInfoErrs: map[string]error{},
+		OnceErrs:    map[string][]error{},
+		MethodCalls: map[string]int{},
+		MaxInFlight: map[string]int{},
+		inFlight:    map[string]int{},
+		Results:     map[string]FakeExecResult{},
+	}
+}
+
+// AddAppJob adds a job with the given meta and a single allocation with a running app-unit task
+func (fe *FakeExecutor) AddAppJob(jobID string, meta map[string]string) {
+	fe.AddAppJobOnNode(jobID, "", meta)
+}
+
+// AddAppJobOnNode is AddAppJob with the allocation placed on the given node
+func (fe *FakeExecutor) AddAppJobOnNode(jobID, nodeID string, meta map[string]string) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	fe.Index++
+	id, index := jobID, fe.Index
+	fe.Jobs[jobID] = &api.Job{
+		ID:             &id,
+		Name:           &id,
+		Meta:           meta,
+		JobModifyIndex: &index,
+	}
+	fe.Allocs[jobID] = append(fe.Allocs[jobID], &api.AllocationListStub{
+		ID:           jobID + "-alloc",
+		JobID:        jobID,
+		NodeID:       nodeID,
+		ClientStatus: api.AllocClientStatusRunning,
+		TaskStates: map[string]*api.TaskState{
+			AppUnitTaskName: {State: "running"},
+		},
+		CreateIndex: fe.Index,
+		ModifyIndex: fe.Index,
+	})
+	if _, ok := fe.Nodes[nodeID]; nodeID != "" && !ok {
+		fe.Nodes[nodeID] = &api.NodeListStub{ID: nodeID, Name: nodeID, Status: api.NodeStatusReady, CreateIndex: fe.Index, ModifyIndex: fe.Index}
+	}
+}
+
+// UpdateJob changes a job with update and bumps its modify index, as registering a new version would
+func (fe *FakeExecutor) UpdateJob(jobID string, update func(job *api.Job)) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	fe.Index++
+	index := fe.Index
+	job := fe.Jobs[jobID]
+	update(job)
+	job.JobModifyIndex = &index
+}
+
+// RemoveJob deletes a job and its allocations
+func (fe *FakeExecutor) RemoveJob(jobID string) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	fe.Index++
+	delete(fe.Jobs, jobID)
+	delete(fe.Allocs, jobID)
+}
+
+// popErr counts a call and returns the next one-off error queued for a method, callers must hold the lock
+func (fe *FakeExecutor) popErr(method string) error {
+	fe.MethodCalls[method]++
+	errs := fe.OnceErrs[method]
+	if len(errs) == 0 {
+		return nil
+	}
+	fe.OnceErrs[method] = errs[1:]
+	return errs[0]
+}
+
+// ExecCalls returns a copy of the execs recorded so far
+func (fe *FakeExecutor) ExecCalls() []FakeExecCall {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	return append([]FakeExecCall(nil), fe.Calls...)
+}
+
+func (fe *FakeExecutor) ListJobs(ctx context.Context, opts *api.JobListOptions, q *api.QueryOptions) ([]*api.JobListStub, *api.QueryMeta, error) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	if err := fe.popErr("ListJobs"); err != nil {
+		return nil, nil, err
+	}
+
+	var filter func(stub *api.JobListStub) bool
+	if q.Filter != "" && !fe.LegacyListJobs {
+		var err error
+		if filter, err = parseFakeFilter(q.Filter); err != nil || fe.RejectFilter {
+			return nil, nil, fmt.Errorf("Unexpected response code: 400 (failed to parse filter %q)", q.Filter)
+		}
+	}
+
+	withMeta := opts != nil && opts.Fields != nil && opts.Fields.Meta
+	stubs := make([]*api.JobListStub, 0, len(fe.Jobs))
+	for id, job := range fe.Jobs {
+		stub := &api.JobListStub{
+			ID:          id,
+			Name:        id,
+			Datacenters: job.Datacenters,
+		}
+		if withMeta {
+			stub.Meta = job.Meta
+		}
+		if job.Name != nil {
+			stub.Name = *job.Name
+		}
+		if job.Type != nil {
+			stub.Type = *job.Type
+		}
+		if job.Status != nil {
+			stub.Status = *job.Status
+		}
+		if job.JobModifyIndex != nil {
+			stub.JobModifyIndex = *job.JobModifyIndex
+		}
+		if filter != nil && !filter(stub) {
+			continue
+		}
+		if fe.LegacyListJobs {
+			stub.Meta = nil
+		}
+		stubs = append(stubs, stub)
+	}
+	sort.Sort(api.JobIDSort(stubs))
+
+	// Page through the stubs using the next job ID as the token
+	meta := &api.QueryMeta{LastIndex: fe.Index}
+	if q.NextToken != "" {
+		i := sort.Search(len(stubs), func(i int) bool { return stubs[i].ID >= q.NextToken })
+		stubs = stubs[i:]
+	}
+	if q.PerPage > 0 && len(stubs) > int(q.PerPage) {
+		meta.NextToken = stubs[q.PerPage].ID
+		stubs = stubs[:q.PerPage]
+	}
+	return stubs, meta, nil
+}
+
+var (
+	fakeMetaFilterPattern   = regexp.MustCompile(`^Meta\.(\w+) == "([^"]*)"$`)
+	fakeNameFilterPattern   = regexp.MustCompile(`^Name matches "([^"]*)"$`)
+	fakeStatusFilterPattern = regexp.MustCompile(`^Status == "([^"]*)"$`)
+)
+
+// parseFakeFilter supports filters of Meta.<key> ==, Name matches and Status == terms joined with and
+// Anything else is rejected the way servers reject a filter they can't parse
+func parseFakeFilter(filter string) (func(stub *api.JobListStub) bool, error) {
+	var terms []func(stub *api.JobListStub) bool
+	for _, part := range strings.Split(filter, " and ") {
+		if m := fakeMetaFilterPattern.FindStringSubmatch(part); m != nil {
+			terms = append(terms, func(stub *api.JobListStub) bool { return stub.Meta[m[1]] == m[2] })
+		} else if m := fakeNameFilterPattern.FindStringSubmatch(part); m != nil {
+			re, err := regexp.Compile(m[1])
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, func(stub *api.JobListStub) bool { return re.MatchString(stub.Name) })
+		} else if m := fakeStatusFilterPattern.FindStringSubmatch(part); m != nil {
+			terms = append(terms, func(stub *api.JobListStub) bool { return stub.Status == m[1] })
+		} else {
+			return nil, fmt.Errorf("unsupported filter term %q", part)
+		}
+	}
+	return func(stub *api.JobListStub) bool {
+		for _, term := range terms {
+			if !term(stub) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func (fe *FakeExecutor) JobInfo(ctx context.Context, jobID string, q *api.QueryOptions) (*api.Job, *api.QueryMeta, error) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	if err := fe.popErr("JobInfo"); err != nil {
+		return nil, nil, err
+	}
+
+	if err, ok := fe.JobInfoErrs[jobID]; ok {
+		return nil, nil, err
+	}
+	job, ok := fe.Jobs[jobID]
+	if !ok {
+		return nil, nil, fmt.Errorf("job not found: %s", jobID)
+	}
+	return job, &api.QueryMeta{}, nil
+}
+
+// JobAllocations blocks like a Nomad blocking query while q.WaitIndex is at or past the fake's index
+func (fe *FakeExecutor) JobAllocations(ctx context.Context, jobID string, q *api.QueryOptions) ([]*api.AllocationListStub, *api.QueryMeta, error) {
+	if err := fe.waitForChange(ctx, q); err != nil {
+		return nil, nil, err
+	}
+
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	if err := fe.popErr("JobAllocations"); err != nil {
+		return nil, nil, err
+	}
+	// Copy the slice, UpdateAllocs replaces its stubs
+	allocs := append([]*api.AllocationListStub(nil), fe.Allocs[jobID]...)
+	return allocs, &api.QueryMeta{LastIndex: fe.Index}, nil
+}
+
+// waitForChange waits until the index moves past q.WaitIndex, q.WaitTime passes or ctx is done
+func (fe *FakeExecutor) waitForChange(ctx context.Context, q *api.QueryOptions) error {
+	if q == nil || q.WaitIndex == 0 {
+		return nil
+	}
+	var timeout <-chan time.Time
+	if q.WaitTime > 0 {
+		timeout = time.After(q.WaitTime)
+	}
+	for {
+		fe.mu.Lock()
+		changed := fe.Index > q.WaitIndex
+		fe.mu.Unlock()
+		if changed {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return nil
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+// UpdateAllocs changes each allocation of a job with update and bumps the index, as a client update would
+func (fe *FakeExecutor) UpdateAllocs(jobID string, update func(alloc *api.AllocationListStub)) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	fe.Index++
+	for i, alloc := range fe.Allocs[jobID] {
+		// Replace rather than modify, so stubs already handed out don't change underneath their callers
+		updated := *alloc
+		updated.TaskStates = map[string]*api.TaskState{}
+		for task, state := range alloc.TaskStates {
+			copied := *state
+			updated.TaskStates[task] = &copied
+		}
+		update(&updated)
+		updated.ModifyIndex = fe.Index
+		fe.Allocs[jobID][i] = &updated
+	}
+}
+
+// ListAllocations lists every job's allocations, it doesn't block on q.WaitIndex but returns at once
+func (fe *FakeExecutor) ListAllocations(ctx context.Context, q *api.QueryOptions) ([]*api.AllocationListStub, *api.QueryMeta, error) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	if err := fe.popErr("ListAllocations"); err != nil {
+		return nil, nil, err
+	}
+	var stubs []*api.AllocationListStub
+	for _, allocs := range fe.Allocs {
+		stubs = append(stubs, allocs...)
+	}
+	sort.Slice(stubs, func(i, j int) bool { return stubs[i].ID < stubs[j].ID })
+	return stubs, &api.QueryMeta{LastIndex: fe.Index}, nil
+}
+
+// ListNodes lists the nodes, it doesn't block on q.WaitIndex but returns at once
+func (fe *FakeExecutor) ListNodes(ctx context.Context, q *api.QueryOptions) ([]*api.NodeListStub, *api.QueryMeta, error) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	if err := fe.popErr("ListNodes"); err != nil {
+		return nil, nil, err
+	}
+	stubs := make([]*api.NodeListStub, 0, len(fe.Nodes))
+	for _, node := range fe.Nodes {
+		stubs = append(stubs, node)
+	}
+	sort.Slice(stubs, func(i, j int) bool { return stubs[i].ID < stubs[j].ID })
+	return stubs, &api.QueryMeta{LastIndex: fe.Index}, nil
+}
+
+func (fe *FakeExecutor) AllocationInfo(ctx context.Context, allocID string, q *api.QueryOptions) (*api.Allocation, *api.QueryMeta, error) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	if err := fe.popErr("AllocationInfo"); err != nil {
+		return nil, nil, err
+	}
+
+	for _, allocs := range fe.Allocs {
+		for _, stub := range allocs {
+			if stub.ID == allocID {
+				return &api.Allocation{
+					ID:     stub.ID,
+					JobID:  stub.JobID,
+					NodeID: stub.NodeID,
+				}, &api.QueryMeta{}, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("allocation not found: %s", allocID)
+}
+
+func (fe *FakeExecutor) Exec(ctx context.Context, alloc *api.Allocation, task string, tty bool, command []string,
+	stdin io.Reader, stdout, stderr io.Writer, sizeCh <-chan api.TerminalSize, q *api.QueryOptions) (int, error) {
+	// The Nomad client reads stdin without checking it, so a nil one would panic against a real cluster
+	if stdin == nil {
+		return -2, errors.New("exec stdin is nil")
+	}
+	// A one-off Exec error fails the exec while it is being set up, before the command has started
+	fe.mu.Lock()
+	onceErr := fe.popErr("Exec")
+	fe.mu.Unlock()
+	var input []byte
+	if onceErr == nil {
+		var err error
+		if input, err = io.ReadAll(stdin); err != nil {
+			return -2, err
+		}
+	}
+
+	fe.mu.Lock()
+	var startedErr error
+	if onceErr == nil && len(fe.StartedExecErrs) > 0 {
+		startedErr, fe.StartedExecErrs = fe.StartedExecErrs[0], fe.StartedExecErrs[1:]
+	}
+	fe.Calls = append(fe.Calls, FakeExecCall{
+		Namespace: q.Namespace,
+		JobID:     alloc.JobID,
+		AllocID:   alloc.ID,
+		NodeID:    alloc.NodeID,
+		Task:      task,
+		TTY:       tty,
+		Command:   command,
+		Stdin:     string(input),
+	})
+	result := fe.Results[alloc.JobID]
+	delay := fe.ExecDelay
+	fe.inFlight[alloc.NodeID]++
+	if fe.inFlight[alloc.NodeID] > fe.MaxInFlight[alloc.NodeID] {
+		fe.MaxInFlight[alloc.NodeID] = fe.inFlight[alloc.NodeID]
+	}
+	fe.mu.Unlock()
+	defer func() {
+		fe.mu.Lock()
+		fe.inFlight[alloc.NodeID]--
+		fe.mu.Unlock()
+	}()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return -2, ctx.Err()
+		}
+	}
+
+	if onceErr != nil {
+		return -2, onceErr
+	}
+	if startedErr != nil {
+		io.WriteString(stdout, result.Stdout)
+		return -2, startedErr
+	}
+	if result.Err != nil {
+		return -2, result.Err
+	}
+	io.WriteString(stdout, result.Stdout)
+	io.WriteString(stderr, result.Stderr)
+	return result.ExitCode, nil
+}
@@ -0,0 +1,172 @@
+package appexec
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/nomad/api"
+)
+
+// RetryPolicy controls how Nomad calls are retried on transient errors
+type RetryPolicy struct {
+	MaxAttempts    int           // total attempts including the first, 1 disables retries
+	InitialBackoff time.Duration // backoff before the second attempt
+	MaxBackoff     time.Duration // upper bound on a single backoff
+	Multiplier     float64       // backoff growth factor per attempt
+	Jitter         float64       // fraction of each backoff that is randomized, between 0 and 1
+}
+
+// DefaultRetryPolicy returns default configuration
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Backoff returns how long to wait after the given failed attempt (starting at 1)
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		// Spread retries out between backoff*(1-jitter) and backoff*(1+jitter)
+		backoff += backoff * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// IsRetryable reports whether an error from a Nomad call is transient and worth retrying
+// 5xx responses, connection resets and websocket closes are retryable, 4xx responses like 404 or ACL denied are not
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if code, ok := errorStatusCode(err); ok {
+		return isRetryableStatus(code)
+	}
+
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr.Code != websocket.ClosePolicyViolation
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// Some errors only surface as text, e.g. exec errors that drop the websocket close code
+	msg := strings.ToLower(err.Error())
+	for _, permanent := range []string{"permission denied", "acl token not found", "not found"} {
+		if strings.Contains(msg, permanent) {
+			return false
+		}
+	}
+	for _, transient := range []string{"connection reset", "connection refused", "broken pipe", "websocket: close", "unexpected eof", "no cluster leader", "i/o timeout"} {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+var statusCodePattern = regexp.MustCompile(`response code: ([0-9]{3})`)
+
+// errorStatusCode extracts the HTTP status code from a Nomad API error, if it has one
+func errorStatusCode(err error) (int, bool) {
+	var respErr api.UnexpectedResponseError
+	if errors.As(err, &respErr) && respErr.HasStatusCode() {
+		return respErr.StatusCode(), true
+	}
+	var respErrPtr *api.UnexpectedResponseError
+	if errors.As(err, &respErrPtr) && respErrPtr.HasStatusCode() {
+		return respErrPtr.StatusCode(), true
+	}
+	// Wrapped errors may only carry the code in their text
+	if m := statusCodePattern.FindStringSubmatch(strings.ToLower(err.Error())); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return code, true
+	}
+	return 0, false
+}
+
+func isRetryableStatus(code int) bool {
+	return code >= 500 || code == 429
+}
+
+// noRetryError marks an error as final whether or not it is transient, e.g. an exec that failed after its command
+// started, which can't be retried without running the command again
+type noRetryError struct {
+	err error
+}
+
+func (e *noRetryError) Error() string { return e.err.Error() }
+func (e *noRetryError) Unwrap() error { return e.err }
+
+// startedExecErr marks the error of an exec that read stdin or received output as final unless RetryStartedExecs is set,
+// as the command may have partly or fully run by then
+func (ae *AppExec) startedExecErr(err error, stdin *countingReader, stdout, stderr *cappedBuffer) error {
+	if !ae.RetryStartedExecs && (stdin.n > 0 || stdout.total+stderr.total > 0) {
+		return &noRetryError{err: err}
+	}
+	return err
+}
+
+// retry calls fn with the AppExec retry policy
+func (ae *AppExec) retry(ctx context.Context, op, id string, fn func() error) error {
+	return ae.RetryPolicy.Do(ctx, op, id, fn)
+}
+
+// Do calls fn until it succeeds, returns a permanent error, or runs out of attempts
+// op and id name the Nomad call and the job or allocation it is for in the attempt logs
+func (p RetryPolicy) Do(ctx context.Context, op, id string, fn func() error) error {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var noRetry *noRetryError
+		retryable := IsRetryable(err) && !errors.As(err, &noRetry)
+		if attempt >= maxAttempts || !retryable || ctx.Err() != nil {
+			slog.Warn("Nomad call failed", "op", op, "id", id, "attempt", attempt, "maxAttempts", maxAttempts, "retryable", retryable, "error", err)
+			return err
+		}
+
+		backoff := p.Backoff(attempt)
+		slog.Warn("Nomad call failed, retrying", "op", op, "id", id, "attempt", attempt, "maxAttempts", maxAttempts, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package appexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "nil error", err: nil, retryable: false},
+		{name: "context canceled", err: context.Canceled, retryable: false},
+		{name: "connection reset", err: fmt.Errorf("get jobs: %w", syscall.ECONNRESET), retryable: true},
+		{name: "websocket close", err: &websocket.CloseError{Code: websocket.CloseAbnormalClosure}, retryable: true},
+		{name: "server error text", err: errors.New("Unexpected response code: 500 (no cluster leader)"), retryable: true},
+		{name: "not found", err: errors.New("Unexpected response code: 404 (job not found)"), retryable: false},
+		{name: "acl denied", err: errors.New("Unexpected response code: 403 (Permission denied)"), retryable: false},
+		{name: "unknown error", err: errors.New("something else"), retryable: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.retryable {
+				t.Errorf("Expected IsRetryable %v, got %v", tt.retryable, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, want := range expected {
+		if got := policy.Backoff(i + 1); got != want {
+			t.Errorf("Expected backoff %v for attempt %d, got %v", want, i+1, got)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for range 100 {
+		got := policy.Backoff(1)
+		if got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Errorf("Backoff %v with jitter is outside range [50ms, 150ms]", got)
+		}
+	}
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	fake := NewFakeExecutor()
+	fake.AddAppJob("app-1", map[string]string{"account_id": "acc-1"})
+	fake.OnceErrs["ListJobs"] = []error{syscall.ECONNRESET}
+	fake.OnceErrs["JobInfo"] = []error{errors.New("Unexpected response code: 503")}
+	fake.OnceErrs["JobAllocations"] = []error{syscall.ECONNRESET}
+	fake.OnceErrs["Exec"] = []error{&websocket.CloseError{Code: websocket.CloseAbnormalClosure}}
+
+	appExec := NewAppExecWithExecutor(fake, 1, Options{})
+	appExec.RetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	jobIDs, err := appExec.GetAppJobs(context.Background(), "acc-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(jobIDs) != 1 {
+		t.Fatalf("Expected 1 job after retries, got %v", jobIDs)
+	}
+
+	results, err := appExec.ExecuteCommandOnApp(context.Background(), "app-1", "echo hi")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if results[0].Err != nil {
+		t.Fatalf("Unexpected exec error: %v", results[0].Err)
+	}
+	calls := fake.ExecCalls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 exec attempts, got %d", len(calls))
+	}
+	if calls[1].Stdin != "echo hi" {
+		t.Errorf("Expected stdin to be replayed on retry, got %q", calls[1].Stdin)
+	}
+}
+
+func TestRetryStartedExec(t *testing.T) {
+	tests := []struct {
+		name          string
+		retryStarted  bool
+		expectedCalls int
+	}{
+		{name: "not retried by default", expectedCalls: 1},
+		{name: "retried when opted in", retryStarted: true, expectedCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := NewFakeExecutor()
+			fake.AddAppJob("app-1", nil)
+			fake.Results["app-1"] = FakeExecResult{Stdout: "partial\n"}
+			fake.StartedExecErrs = []error{&websocket.CloseError{Code: websocket.CloseAbnormalClosure}}
+
+			appExec := NewAppExecWithExecutor(fake, 1, Options{})
+			appExec.RetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+			appExec.RetryStartedExecs = tt.retryStarted
+
+			results, err := appExec.ExecuteCommandOnApp(context.Background(), "app-1", "echo hi")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if calls := fake.ExecCalls(); len(calls) != tt.expectedCalls {
+				t.Fatalf("Expected %d exec attempts, got %d", tt.expectedCalls, len(calls))
+			}
+			if failed := results[0].Err != nil; failed == tt.retryStarted {
+				t.Errorf("Expected exec error only without retries, got %v", results[0].Err)
+			}
+		})
+	}
+}
+
+func TestRetryPermanentError(t *testing.T) {
+	fake := NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	fake.OnceErrs["JobAllocations"] = []error{errors.New("Unexpected response code: 404 (job not found)"), syscall.ECONNRESET}
+
+	appExec := NewAppExecWithExecutor(fake, 1, Options{})
+	appExec.RetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	if _, err := appExec.GetAppUnitAllocId(context.Background(), "app-1"); err == nil {
+		t.Fatal("Expected permanent error to be returned without retrying")
+	}
+	if remaining := len(fake.OnceErrs["JobAllocations"]); remaining != 1 {
+		t.Errorf("Expected 1 queued error left after a single attempt, got %d", remaining)
+	}
+}
+
+func TestNoRetryUnseekableStdin(t *testing.T) {
+	fake := NewFakeExecutor()
+	fake.AddAppJob("app-1", nil)
+	fake.OnceErrs["Exec"] = []error{&websocket.CloseError{Code: websocket.CloseAbnormalClosure}}
+
+	appExec := NewAppExecWithExecutor(fake, 1, Options{})
+	appExec.RetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	allocs, err := appExec.GetAppUnitAllocs(context.Background(), "app-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// A streamed stdin can't be replayed, so the transient error fails the exec
+	stdin := io.MultiReader(strings.NewReader("echo hi"))
+	result := appExec.ExecuteStdinOnAlloc(context.Background(), "app-1", allocs[0], stdin)
+	if result.Err == nil {
+		t.Fatal("Expected the exec to fail without retrying")
+	}
+	if calls := fake.ExecCalls(); len(calls) != 1 {
+		t.Errorf("Expected a single exec attempt, got %d", len(calls))
+	}
+}
@@ -2,6 +2,7 @@ package datagen
 
 import (
 	"fmt"
+	"io"
 	"math/rand"
 	"strings"
 )
@@ -71,34 +72,90 @@ func (dg *BackupDataGen) GenerateFileSizeType(sizeType *FileSizeTypeDataGen) str
 
 	basePath := sizeType.Name
 	for !sizeType.IsDone() {
-		// If we have reached the max file count per directory, add a new directory
-		if len(cmds)%dg.MaxFileCountPerDir == 0 {
-			basePath = basePath + "/" + GenerateRandomName()
-		}
-
-		additionPath := basePath + "/" + GenerateRandomName()
-		// Generate the files in the directory
-		cmd := dg.GenerateMultipleFilesCommand(sizeType, additionPath, dg.MaxFileCountPerDir)
-		cmds = append(cmds, cmd)
+		cmds = append(cmds, dg.generateDirectoryCommand(sizeType, &basePath, len(cmds)))
 	}
 
 	return strings.Join(cmds, "\n")
 }
 
+// generateDirectoryCommand generates the commands for the next directory of files of a size type
+// basePath is the parent of the size type's current directories, starting at the size type's name and nested a
+// level deeper every MaxFileCountPerDir directories
+func (dg *BackupDataGen) generateDirectoryCommand(sizeType *FileSizeTypeDataGen, basePath *string, dirs int) string {
+	// If we have reached the max file count per directory, add a new directory
+	if dirs%dg.MaxFileCountPerDir == 0 {
+		*basePath = *basePath + "/" + GenerateRandomName()
+	}
+
+	additionPath := *basePath + "/" + GenerateRandomName()
+	// Generate the files in the directory
+	return dg.GenerateMultipleFilesCommand(sizeType, additionPath, dg.MaxFileCountPerDir)
+}
+
 // GenerateBackupDataOnApp generates all the commands to create the desired file distribution on the app
 // No data is actually generated until the commands are executed
+// The commands are held in memory, NewBackupDataReader streams them instead for large distributions
 func (dg *BackupDataGen) GenerateBackupDataOnApp() string {
-	var backupDataGenCmds []string
+	var cmds strings.Builder
+	io.Copy(&cmds, dg.NewBackupDataReader())
+	return cmds.String()
+}
+
+// BackupDataReader generates the commands of a new file distribution as they are read, a directory of files at a time,
+// so memory stays flat however large the distribution is
+// It can't be rewound, an exec reading it from stdin isn't retried
+type BackupDataReader struct {
+	dg        *BackupDataGen
+	sizeTypes []*FileSizeTypeDataGen // size types left to generate, the first one in progress
+	basePath  string                 // parent of the current size type's directories
+	dirs      int                    // directories generated of the current size type
+	chunk     []byte                 // commands of the current directory, reused for each one
+	pending   []byte                 // unread part of chunk
+}
 
-	fileSizesTemplate := NewFileSizeDistribution(dg.SizeChoice)
+// NewBackupDataReader creates a reader of the commands to create a new file distribution on the app, one per line
+func (dg *BackupDataGen) NewBackupDataReader() *BackupDataReader {
+	return &BackupDataReader{
+		dg:        dg,
+		sizeTypes: NewFileSizeDistribution(dg.SizeChoice).SizeDistributions,
+	}
+}
 
-	for _, sizeType := range fileSizesTemplate.SizeDistributions {
+// Read reads the next commands, generating the next directory's once the previous ones have been read
+func (r *BackupDataReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if !r.next() {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
 
-		cmds := dg.GenerateFileSizeType(sizeType)
-		backupDataGenCmds = append(backupDataGenCmds, cmds)
+// next generates the commands of the next directory, false once every size type is done
+func (r *BackupDataReader) next() bool {
+	for len(r.sizeTypes) > 0 && r.sizeTypes[0].IsDone() {
+		r.sizeTypes = r.sizeTypes[1:]
+		r.dirs = 0
 	}
+	if len(r.sizeTypes) == 0 {
+		return false
+	}
+
+	if r.dirs == 0 {
+		r.basePath = r.sizeTypes[0].Name
+	}
+	cmd := r.dg.generateDirectoryCommand(r.sizeTypes[0], &r.basePath, r.dirs)
+	r.dirs++
+	r.chunk = append(append(r.chunk[:0], cmd...), '\n')
+	r.pending = r.chunk
+	return true
+}
 
-	return strings.Join(backupDataGenCmds, "\n")
+// Estimate returns the expected bytes and files of each size category GenerateBackupDataOnApp generates
+func (dg *BackupDataGen) Estimate() []CategoryEstimate {
+	return NewFileSizeDistribution(dg.SizeChoice).Estimate()
 }
 
 func GenerateRandomName() string {
@@ -1,8 +1,13 @@
 package datagen
 
 import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestNewBackupDataGen(t *testing.T) {
@@ -275,6 +280,43 @@ func TestGenerateBackupDataOnApp(t *testing.T) {
 	}
 }
 
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		sizeChoice         string
+		expectedCategories []string
+		expectedTotal      int64
+	}{
+		{sizeChoice: "medium", expectedCategories: []string{"large", "medium", "small"}, expectedTotal: (TotalSize300MB + TotalSize2GB) / 2},
+		{sizeChoice: "large", expectedCategories: []string{"large", "medium", "small"}, expectedTotal: (TotalSize5GB + TotalSize10GB) / 2},
+		{sizeChoice: "p95", expectedCategories: []string{"p95"}, expectedTotal: (TotalSize2GB + TotalSize5GB) / 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sizeChoice, func(t *testing.T) {
+			estimates := NewBackupDataGen("./backup", 30, tt.sizeChoice).Estimate()
+			if len(estimates) != len(tt.expectedCategories) {
+				t.Fatalf("Expected %d categories, got %+v", len(tt.expectedCategories), estimates)
+			}
+
+			var total int64
+			for i, estimate := range estimates {
+				if estimate.Name != tt.expectedCategories[i] {
+					t.Errorf("Expected category %s, got %s", tt.expectedCategories[i], estimate.Name)
+				}
+				meanFileSize := int64(estimate.MinFileSize+estimate.MaxFileSize) / 2
+				if estimate.Files == 0 || estimate.Files*meanFileSize < estimate.Bytes {
+					t.Errorf("Expected %s files to cover %d bytes, got %d", estimate.Name, estimate.Bytes, estimate.Files)
+				}
+				total += estimate.Bytes
+			}
+			// The shares are rounded down per category and from the random size the distribution was built with
+			if diff := tt.expectedTotal - total; diff < 0 || diff > tt.expectedTotal/100 {
+				t.Errorf("Expected about %d bytes in total, got %d", tt.expectedTotal, total)
+			}
+		})
+	}
+}
+
 func TestGenerateRandomName(t *testing.T) {
 	// Test multiple generations to ensure randomness and constraints
 	names := make(map[string]bool)
@@ -548,3 +590,56 @@ func BenchmarkGenerateBackupDataOnApp(b *testing.B) {
 		gen.GenerateBackupDataOnApp()
 	}
 }
+
+func TestBackupDataReader(t *testing.T) {
+	gen := NewBackupDataGen("./backup", 30, "fileCount")
+	reader := gen.NewBackupDataReader()
+
+	// Read a little at a time as exec stdin does, checking the reader only ever holds one directory's commands
+	headCmd := regexp.MustCompile(`^head -c (\d+) /dev/urandom > \./backup/fileCount(/\w+){3,}$`)
+	var total, files int64
+	var maxChunk, maxDir int
+	scanner := bufio.NewScanner(iotest.HalfReader(reader))
+	for scanner.Scan() {
+		maxChunk = max(maxChunk, cap(reader.chunk))
+		maxDir = max(maxDir, len(reader.chunk))
+		line := scanner.Text()
+		if strings.HasPrefix(line, "mkdir -p ./backup/fileCount/") {
+			continue
+		}
+		match := headCmd.FindStringSubmatch(line)
+		if match == nil {
+			t.Fatalf("Unexpected command line %q", line)
+		}
+		size, _ := strconv.ParseInt(match[1], 10, 64)
+		total += size
+		files++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if total < TotalSize300MB || total > TotalSize500MB+50*1024 {
+		t.Errorf("Expected 300MB to 500MB of files, got %d bytes", total)
+	}
+	if files < 1000 {
+		t.Errorf("Expected thousands of files, got %d", files)
+	}
+	// Paths nest deeper as directories are added, so the buffer grows with the longest directory's commands and no more
+	if maxChunk > 2*maxDir {
+		t.Errorf("Expected the reader to hold at most one directory of commands, got a %d byte buffer for %d bytes", maxChunk, maxDir)
+	}
+	if n, err := reader.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("Expected EOF once the distribution is done, got %d bytes and %v", n, err)
+	}
+}
+
+func BenchmarkBackupDataReader(b *testing.B) {
+	gen := NewBackupDataGen("./benchmark", 30, "medium")
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		io.Copy(io.Discard, gen.NewBackupDataReader())
+	}
+}
@@ -48,6 +48,8 @@ func MediumSiteSizeDistributionConfig() *FileSizeDistribution {
 	size := rand.Intn(int(TotalSize2GB-TotalSize300MB+1)) + int(TotalSize300MB)
 
 	return &FileSizeDistribution{
+		MinTotalSize: int(TotalSize300MB),
+		MaxTotalSize: int(TotalSize2GB),
 		SizeDistributions: []*FileSizeTypeDataGen{
 			{
 				Name: "large",
@@ -82,6 +84,8 @@ func LargeSiteSizeDistributionConfig() *FileSizeDistribution {
 	size := rand.Intn(int(TotalSize10GB-TotalSize5GB+1)) + int(TotalSize5GB)
 
 	return &FileSizeDistribution{
+		MinTotalSize: int(TotalSize5GB),
+		MaxTotalSize: int(TotalSize10GB),
 		SizeDistributions: []*FileSizeTypeDataGen{
 			{
 				Name: "large",
@@ -115,6 +119,8 @@ func LargeSiteSizeDistributionConfig() *FileSizeDistribution {
 func P95FileCountSizeDistributionConfig() *FileSizeDistribution {
 	// Generate a random size between MinMedTotalSize and MaxMedTotalSize
 	return &FileSizeDistribution{
+		MinTotalSize: int(TotalSize2GB),
+		MaxTotalSize: int(TotalSize5GB),
 		SizeDistributions: []*FileSizeTypeDataGen{
 			{
 				Name: "p95",
@@ -131,6 +137,8 @@ func P95FileCountSizeDistributionConfig() *FileSizeDistribution {
 func P90FileCountSizeDistributionConfig() *FileSizeDistribution {
 	// Generate a random size between MinMedTotalSize and MaxMedTotalSize
 	return &FileSizeDistribution{
+		MinTotalSize: int(TotalSize2GB),
+		MaxTotalSize: int(TotalSize5GB),
 		SizeDistributions: []*FileSizeTypeDataGen{
 			{
 				Name: "p90",
@@ -147,6 +155,8 @@ func P90FileCountSizeDistributionConfig() *FileSizeDistribution {
 func P75FileCountSizeDistributionConfig() *FileSizeDistribution {
 	// Generate a random size between MinMedTotalSize and MaxMedTotalSize
 	return &FileSizeDistribution{
+		MinTotalSize: int(TotalSize2GB),
+		MaxTotalSize: int(TotalSize5GB),
 		SizeDistributions: []*FileSizeTypeDataGen{
 			{
 				Name: "p75",
@@ -163,6 +173,8 @@ func P75FileCountSizeDistributionConfig() *FileSizeDistribution {
 func P50FileCountSizeDistributionConfig() *FileSizeDistribution {
 	// Generate a random size between MinMedTotalSize and MaxMedTotalSize
 	return &FileSizeDistribution{
+		MinTotalSize: int(TotalSize2GB),
+		MaxTotalSize: int(TotalSize5GB),
 		SizeDistributions: []*FileSizeTypeDataGen{
 			{
 				Name: "p50",
@@ -179,6 +191,8 @@ func P50FileCountSizeDistributionConfig() *FileSizeDistribution {
 func FileCountSizeDistributionConfig() *FileSizeDistribution {
 	// Generate a random size between MinMedTotalSize and MaxMedTotalSize
 	return &FileSizeDistribution{
+		MinTotalSize: int(TotalSize300MB),
+		MaxTotalSize: int(TotalSize500MB),
 		SizeDistributions: []*FileSizeTypeDataGen{
 			{
 				Name: "fileCount",
@@ -212,3 +226,39 @@ func NewFileSizeDistribution(sizeChoice string) *FileSizeDistribution {
 		return MediumSiteSizeDistributionConfig()
 	}
 }
+
+// CategoryEstimate is the expected data of one file size category for a single generation of a distribution
+type CategoryEstimate struct {
+	Name        string `json:"name"`
+	MinFileSize int    `json:"min_file_size"`
+	MaxFileSize int    `json:"max_file_size"`
+	Bytes       int64  `json:"bytes"` // expected bytes, averaged over the random total size
+	Files       int64  `json:"files"` // expected file count
+}
+
+// Estimate returns the expected bytes and files of each size category, averaged over the distribution's random total size
+// Each category keeps its share of the total from this distribution's sizes
+func (d *FileSizeDistribution) Estimate() []CategoryEstimate {
+	var total int64
+	for _, sizeType := range d.SizeDistributions {
+		total += sizeType.MaxTotalSize
+	}
+	expectedTotal := (int64(d.MinTotalSize) + int64(d.MaxTotalSize)) / 2
+
+	estimates := make([]CategoryEstimate, 0, len(d.SizeDistributions))
+	for _, sizeType := range d.SizeDistributions {
+		estimate := CategoryEstimate{
+			Name:        sizeType.Name,
+			MinFileSize: sizeType.DataGen.MinSizeInBytes,
+			MaxFileSize: sizeType.DataGen.MaxSizeInBytes,
+		}
+		if total > 0 {
+			estimate.Bytes = int64(float64(expectedTotal) * float64(sizeType.MaxTotalSize) / float64(total))
+		}
+		if meanFileSize := int64(estimate.MinFileSize+estimate.MaxFileSize) / 2; meanFileSize > 0 {
+			estimate.Files = (estimate.Bytes + meanFileSize - 1) / meanFileSize
+		}
+		estimates = append(estimates, estimate)
+	}
+	return estimates
+}